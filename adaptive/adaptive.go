@@ -0,0 +1,118 @@
+// Package adaptive adapts the poll interval of each Jenkins instance to its activity and
+// load. Instances with running or queued builds are polled at the minimum interval, idle ones
+// back off towards the maximum. Slow responses and Retry-After headers slow polling down.
+package adaptive
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits bound the interval. Base is used after something changed.
+type Limits struct {
+	Min  time.Duration
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (l Limits) clamp(d time.Duration) time.Duration {
+	if d < l.Min {
+		return l.Min
+	}
+	if d > l.Max {
+		return l.Max
+	}
+	return d
+}
+
+// Observation describes one poll of an instance.
+type Observation struct {
+	// Active is set if a monitored build is running or queued.
+	Active bool
+	// Changed is set if a monitored job changed.
+	Changed bool
+	// Latency is the time until the response headers arrived.
+	Latency time.Duration
+	// RetryAfter is the delay the server asked for with a 429 or 503 response.
+	RetryAfter time.Duration
+}
+
+// idleFactor is applied to the interval of an instance with nothing to report.
+const idleFactor = 1.5
+
+// slowFactor is the latency above the average that counts as high load.
+const slowFactor = 2
+
+// minSlowLatency is the latency below which an instance is never considered loaded.
+const minSlowLatency = time.Second
+
+type pace struct {
+	interval time.Duration
+	next     time.Time
+	// latency is the moving average of the latencies.
+	latency time.Duration
+}
+
+// Pacer keeps the intervals of the instances by URL. It is safe for concurrent use.
+type Pacer struct {
+	mutex  sync.Mutex
+	limits Limits
+	paces  map[string]*pace
+}
+
+// New returns a Pacer polling all instances at the base interval.
+func New(limits Limits) *Pacer {
+	return &Pacer{limits: limits, paces: make(map[string]*pace)}
+}
+
+// Reset replaces the limits and polls all instances at the base interval again, starting
+// now.
+func (p *Pacer) Reset(limits Limits) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.limits = limits
+	p.paces = make(map[string]*pace)
+}
+
+// Due reports whether the instance is to be polled at now.
+func (p *Pacer) Due(url string, now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pc, ok := p.paces[url]
+	// the ticker may fire slightly early
+	return !ok || !now.Before(pc.next.Add(-time.Second))
+}
+
+// Observe adapts the interval of the instance to a poll at now and returns it.
+func (p *Pacer) Observe(url string, o Observation, now time.Time) time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pc, ok := p.paces[url]
+	if !ok {
+		pc = &pace{interval: p.limits.Base, latency: o.Latency}
+		p.paces[url] = pc
+	}
+	slow := o.Latency > minSlowLatency && o.Latency > slowFactor*pc.latency
+	if o.Latency > 0 {
+		pc.latency = (pc.latency*7 + o.Latency) / 8
+	}
+	switch {
+	case o.RetryAfter > 0:
+		pc.interval *= 2
+	case slow:
+		pc.interval *= 2
+	case o.Active:
+		pc.interval = p.limits.Min
+	case o.Changed:
+		pc.interval = p.limits.Base
+	default:
+		pc.interval = time.Duration(float64(pc.interval) * idleFactor)
+	}
+	pc.interval = p.limits.clamp(pc.interval)
+	// the server knows best when it can answer again, even beyond the maximum
+	if pc.interval < o.RetryAfter {
+		pc.interval = o.RetryAfter
+	}
+	pc.next = now.Add(pc.interval)
+	return pc.interval
+}
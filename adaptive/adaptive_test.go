@@ -0,0 +1,76 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+)
+
+var limits = Limits{Min: 10 * time.Second, Base: 30 * time.Second, Max: 5 * time.Minute}
+
+func TestObserve(t *testing.T) {
+	const url = "http://jenkins-a/"
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	p := New(limits)
+	ms := time.Millisecond
+	steps := []struct {
+		name string
+		o    Observation
+		want time.Duration
+	}{
+		{"idle", Observation{Latency: 100 * ms}, 45 * time.Second},
+		{"still idle", Observation{Latency: 100 * ms}, 67500 * ms},
+		{"running build", Observation{Active: true, Latency: 100 * ms}, limits.Min},
+		{"idle after the build", Observation{Latency: 100 * ms}, 15 * time.Second},
+		{"changed", Observation{Changed: true, Latency: 100 * ms}, limits.Base},
+		{"latency below a second", Observation{Changed: true, Latency: 900 * ms}, limits.Base},
+		{"slow", Observation{Active: true, Latency: 2 * time.Second}, 60 * time.Second},
+		{"idle", Observation{}, 90 * time.Second},
+		{"idle", Observation{}, 135 * time.Second},
+		{"idle", Observation{}, 202500 * ms},
+		{"idle at the maximum", Observation{}, limits.Max},
+		{"idle at the maximum", Observation{}, limits.Max},
+		{"Retry-After beyond the maximum", Observation{RetryAfter: 10 * time.Minute}, 10 * time.Minute},
+		{"Retry-After below the maximum", Observation{RetryAfter: 20 * time.Second}, limits.Max},
+		{"running build", Observation{Active: true}, limits.Min},
+		{"Retry-After above the minimum", Observation{Active: true, RetryAfter: 15 * time.Second}, 20 * time.Second},
+	}
+	for i, step := range steps {
+		if !p.Due(url, now) {
+			t.Fatalf("step %d %s: not due", i, step.name)
+		}
+		got := p.Observe(url, step.o, now)
+		if got != step.want {
+			t.Errorf("step %d %s: interval %v, want %v", i, step.name, got, step.want)
+		}
+		// the ticker may fire a second early
+		if p.Due(url, now.Add(got-2*time.Second)) || !p.Due(url, now.Add(got-time.Second)) {
+			t.Errorf("step %d %s: not due exactly after %v", i, step.name, got)
+		}
+		now = now.Add(got)
+	}
+}
+
+func TestPacerInstancesAndReset(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	p := New(limits)
+	p.Observe("a", Observation{Active: true}, now)
+	p.Observe("b", Observation{}, now)
+	later := now.Add(20 * time.Second)
+	if !p.Due("a", later) || p.Due("b", later) {
+		t.Errorf("due after 20s: a %v, b %v, want only a", p.Due("a", later), p.Due("b", later))
+	}
+	if !p.Due("unknown", now) {
+		t.Error("an instance not polled yet is not due")
+	}
+
+	p.Reset(Limits{Min: time.Minute, Base: 2 * time.Minute, Max: 10 * time.Minute})
+	if !p.Due("b", now) {
+		t.Error("b is not due after Reset")
+	}
+	if got := p.Observe("b", Observation{Changed: true}, now); got != 2*time.Minute {
+		t.Errorf("interval after Reset = %v, want the new base", got)
+	}
+	if got := p.Observe("a", Observation{Active: true}, now); got != time.Minute {
+		t.Errorf("interval of an active instance after Reset = %v, want the new minimum", got)
+	}
+}
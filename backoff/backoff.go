@@ -0,0 +1,214 @@
+// Package backoff decides when failing Jenkins instances are polled again. Each instance
+// backs off exponentially while it fails. After a number of failures its circuit opens: it
+// is considered unreachable and only probed occasionally until it answers again.
+package backoff
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// State is the health of an instance.
+type State int
+
+const (
+	// Online instances are polled at every tick.
+	Online State = iota
+	// Failing instances failed recently and are polled again after a growing delay.
+	Failing
+	// Unreachable instances failed too often in a row and are only probed.
+	Unreachable
+)
+
+func (s State) String() string {
+	switch s {
+	case Failing:
+		return "failing"
+	case Unreachable:
+		return "unreachable"
+	default:
+		return "online"
+	}
+}
+
+// Policy configures the delays. Zero fields take the value of DefaultPolicy.
+type Policy struct {
+	// Initial is the delay after the first failure, doubled with each further failure.
+	Initial time.Duration
+	// Max limits the delay while an instance is failing.
+	Max time.Duration
+	// Jitter spreads the delays randomly by up to this fraction in both directions.
+	Jitter float64
+	// Threshold is the number of failures in a row that open the circuit.
+	Threshold int
+	// Probe is the delay between two probes of an unreachable instance.
+	Probe time.Duration
+}
+
+// DefaultPolicy waits 10s, 20s and then probes every 5 minutes after the third failure.
+var DefaultPolicy = Policy{
+	Initial:   10 * time.Second,
+	Max:       2 * time.Minute,
+	Jitter:    0.2,
+	Threshold: 3,
+	Probe:     5 * time.Minute,
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.Initial <= 0 {
+		p.Initial = DefaultPolicy.Initial
+	}
+	if p.Max <= 0 {
+		p.Max = DefaultPolicy.Max
+	}
+	if p.Max < p.Initial {
+		p.Max = p.Initial
+	}
+	if p.Jitter < 0 || p.Jitter >= 1 {
+		p.Jitter = DefaultPolicy.Jitter
+	}
+	if p.Threshold <= 0 {
+		p.Threshold = DefaultPolicy.Threshold
+	}
+	if p.Probe <= 0 {
+		p.Probe = DefaultPolicy.Probe
+	}
+	return p
+}
+
+// Delay returns the delay without jitter after the given number of failures in a row.
+func (p Policy) Delay(failures int) time.Duration {
+	p = p.withDefaults()
+	if failures <= 0 {
+		return 0
+	}
+	if failures >= p.Threshold {
+		return p.Probe
+	}
+	delay := p.Initial
+	for i := 1; i < failures && delay < p.Max; i++ {
+		delay *= 2
+	}
+	if delay > p.Max {
+		delay = p.Max
+	}
+	return delay
+}
+
+// Change is a change of an instance between Online and Unreachable.
+type Change struct {
+	URL   string
+	State State
+	// Since is the time of the first failure in a row.
+	Since time.Time
+	// Err is the last error of an unreachable instance.
+	Err error
+}
+
+// Status is the health of one instance.
+type Status struct {
+	State    State
+	Failures int
+	// Since is the time of the first failure in a row.
+	Since time.Time
+	// Next is the earliest time the instance is polled again.
+	Next time.Time
+	Err  error
+}
+
+// Breaker keeps the health of the instances by URL. It is safe for concurrent use.
+type Breaker struct {
+	mutex     sync.Mutex
+	policy    Policy
+	instances map[string]*Status
+	random    *rand.Rand
+}
+
+// New returns a Breaker with all instances online.
+func New(policy Policy) *Breaker {
+	return &Breaker{
+		policy:    policy.withDefaults(),
+		instances: make(map[string]*Status),
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetPolicy replaces the policy. Delays already scheduled are kept.
+func (b *Breaker) SetPolicy(policy Policy) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.policy = policy.withDefaults()
+}
+
+// Allow reports whether the instance may be polled at now.
+func (b *Breaker) Allow(url string, now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s, ok := b.instances[url]
+	return !ok || !now.Before(s.Next)
+}
+
+// Status returns the health of the instance.
+func (b *Breaker) Status(url string) Status {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if s, ok := b.instances[url]; ok {
+		return *s
+	}
+	return Status{}
+}
+
+// Success records a successful poll. It returns a change if the instance was unreachable.
+func (b *Breaker) Success(url string, now time.Time) (Change, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s, ok := b.instances[url]
+	if !ok {
+		return Change{}, false
+	}
+	delete(b.instances, url)
+	if s.State != Unreachable {
+		return Change{}, false
+	}
+	return Change{URL: url, State: Online, Since: s.Since}, true
+}
+
+// Failure records a failed poll and schedules the next one. It returns a change if the
+// circuit opened.
+func (b *Breaker) Failure(url string, err error, now time.Time) (Change, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s, ok := b.instances[url]
+	if !ok {
+		s = &Status{State: Failing, Since: now}
+		b.instances[url] = s
+	}
+	s.Failures++
+	s.Err = err
+	s.Next = now.Add(b.jitter(b.policy.Delay(s.Failures)))
+	if s.State == Failing && s.Failures >= b.policy.Threshold {
+		s.State = Unreachable
+		return Change{URL: url, State: Unreachable, Since: s.Since, Err: err}, true
+	}
+	return Change{}, false
+}
+
+// Forget drops the health of instances no longer monitored.
+func (b *Breaker) Forget(keep func(url string) bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for url := range b.instances {
+		if !keep(url) {
+			delete(b.instances, url)
+		}
+	}
+}
+
+func (b *Breaker) jitter(delay time.Duration) time.Duration {
+	if b.policy.Jitter == 0 {
+		return delay
+	}
+	spread := (b.random.Float64()*2 - 1) * b.policy.Jitter
+	return delay + time.Duration(float64(delay)*spread)
+}
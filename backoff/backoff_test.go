@@ -0,0 +1,146 @@
+package backoff
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errRefused = errors.New("connection refused")
+
+// TestBreaker walks an instance through failing, unreachable, the probes and back online.
+func TestBreaker(t *testing.T) {
+	const url = "http://jenkins-a/"
+	start := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+	b := New(Policy{Initial: 10 * time.Second, Max: time.Minute, Jitter: 0, Threshold: 3, Probe: 5 * time.Minute})
+	steps := []struct {
+		at      int
+		success bool
+		// state and next are the status after the step, next in seconds from start
+		state State
+		next  int
+		// change is the state of the returned change, -1 for none
+		change State
+	}{
+		{at: 0, state: Failing, next: 10, change: -1},
+		{at: 10, state: Failing, next: 30, change: -1},
+		// the third failure opens the circuit
+		{at: 30, state: Unreachable, next: 330, change: Unreachable},
+		// a failed probe keeps it open without another change
+		{at: 330, state: Unreachable, next: 630, change: -1},
+		// a successful probe closes it
+		{at: 630, success: true, state: Online, change: Online},
+		// failing again starts over
+		{at: 700, state: Failing, next: 710, change: -1},
+		// recovering before the circuit opened is no change
+		{at: 710, success: true, state: Online, change: -1},
+	}
+	for i, step := range steps {
+		if !b.Allow(url, at(step.at)) {
+			t.Fatalf("step %d: not allowed at %ds", i, step.at)
+		}
+		var change Change
+		var changed bool
+		if step.success {
+			change, changed = b.Success(url, at(step.at))
+		} else {
+			change, changed = b.Failure(url, errRefused, at(step.at))
+		}
+		if step.change < 0 && changed {
+			t.Errorf("step %d: unexpected change %+v", i, change)
+		}
+		if step.change >= 0 {
+			if !changed || change.State != step.change || change.URL != url {
+				t.Errorf("step %d: change %+v, %v, want state %v", i, change, changed, step.change)
+			}
+		}
+		status := b.Status(url)
+		if status.State != step.state {
+			t.Errorf("step %d: state %v, want %v", i, status.State, step.state)
+		}
+		if step.state == Online {
+			continue
+		}
+		if !status.Next.Equal(at(step.next)) {
+			t.Errorf("step %d: next poll at %v, want %v", i, status.Next.Sub(start), at(step.next).Sub(start))
+		}
+		// no poll before the next one is due
+		if b.Allow(url, at(step.next).Add(-time.Second)) {
+			t.Errorf("step %d: allowed before %ds", i, step.next)
+		}
+	}
+}
+
+func TestBreakerChanges(t *testing.T) {
+	start := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	b := New(Policy{Jitter: 0, Threshold: 2})
+	b.Failure("a", errors.New("timeout"), start)
+	change, ok := b.Failure("a", errRefused, start.Add(time.Minute))
+	want := Change{URL: "a", State: Unreachable, Since: start, Err: errRefused}
+	if !ok || change != want {
+		t.Errorf("unreachable change = %+v, %v, want %+v", change, ok, want)
+	}
+	change, ok = b.Success("a", start.Add(10*time.Minute))
+	want = Change{URL: "a", State: Online, Since: start}
+	if !ok || change != want {
+		t.Errorf("back online change = %+v, %v, want %+v", change, ok, want)
+	}
+	if _, ok := b.Success("b", start); ok {
+		t.Error("a successful poll of a healthy instance is a change")
+	}
+
+	b.Failure("a", errRefused, start)
+	b.Failure("b", errRefused, start)
+	b.Forget(func(url string) bool { return url == "b" })
+	if b.Status("a").State != Online || b.Status("b").State != Failing {
+		t.Errorf("after Forget: a %v, b %v", b.Status("a").State, b.Status("b").State)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	start := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	b := New(Policy{Initial: 10 * time.Second, Jitter: 0.2})
+	min, max := 8*time.Second, 12*time.Second
+	var below, above bool
+	for i := 0; i < 1000; i++ {
+		b.Failure("a", errRefused, start)
+		delay := b.Status("a").Next.Sub(start)
+		if delay < min || delay > max {
+			t.Fatalf("delay %v outside of [%v, %v]", delay, min, max)
+		}
+		below = below || delay < 10*time.Second
+		above = above || delay > 10*time.Second
+		b.Success("a", start)
+	}
+	if !below || !above {
+		t.Error("the jitter does not spread in both directions")
+	}
+}
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{Initial: 10 * time.Second, Max: 35 * time.Second, Threshold: 5, Probe: 10 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 35 * time.Second},
+		{4, 35 * time.Second},
+		{5, 10 * time.Minute},
+		{9, 10 * time.Minute},
+	}
+	for _, test := range tests {
+		if got := p.Delay(test.failures); got != test.want {
+			t.Errorf("Delay(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+	// zero fields take the defaults
+	if got := (Policy{}).Delay(3); got != DefaultPolicy.Probe {
+		t.Errorf("default Delay(3) = %v, want %v", got, DefaultPolicy.Probe)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+// batchSettings is stored as JSON in the "Batching" setting.
+type batchSettings struct {
+	// Window is the number of seconds events are collected before they are released. 0
+	// disables batching.
+	Window int
+	// Threshold is the number of events in one window above which they are grouped into
+	// one notification. Defaults to 3.
+	Threshold int
+	// JobInterval is the minimum number of seconds between two notifications of the same
+	// job. 0 disables the rate limit.
+	JobInterval int
+}
+
+func getBatchSettings() batchSettings {
+	settings := walk.App().Settings()
+	var batch batchSettings
+	batchStr, ok := settings.Get("Batching")
+	if !ok || batchStr == "" {
+		return batch
+	}
+	err := json.Unmarshal([]byte(batchStr), &batch)
+	if err != nil {
+		log.Println("getBatchSettings:", err)
+	}
+	return batch
+}
+
+// batcher protects against notification floods. It drops events of jobs that notified
+// within the job interval and groups events that arrive in the same window once there are
+// more than the threshold.
+type batcher struct {
+	mutex       sync.Mutex
+	window      time.Duration
+	threshold   int
+	jobInterval time.Duration
+	lastNotify  map[string]time.Time
+	pending     []*jobEvent
+	timer       *time.Timer
+	release     func(ev *jobEvent)
+}
+
+// newBatcher returns nil if neither batching nor rate limiting is enabled.
+func newBatcher(settings batchSettings, release func(ev *jobEvent)) *batcher {
+	if settings.Window <= 0 && settings.JobInterval <= 0 {
+		return nil
+	}
+	if settings.Threshold <= 0 {
+		settings.Threshold = 3
+	}
+	return &batcher{
+		window:      time.Duration(settings.Window) * time.Second,
+		threshold:   settings.Threshold,
+		jobInterval: time.Duration(settings.JobInterval) * time.Second,
+		lastNotify:  make(map[string]time.Time),
+		release:     release,
+	}
+}
+
+// admit returns outcomeDeliver if the event may be released immediately. Otherwise it was
+// dropped by the rate limit or is held back until the window ends.
+func (b *batcher) admit(ev *jobEvent, now time.Time) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.jobInterval > 0 && ev.Job != nil {
+		b.prune(now)
+		key := ev.Job.Jenkins + "\x00" + ev.Job.Name
+		if last, ok := b.lastNotify[key]; ok && now.Sub(last) < b.jobInterval {
+			return outcomeRateLimited
+		}
+		b.lastNotify[key] = now
+	}
+	if b.window <= 0 {
+		return outcomeDeliver
+	}
+	b.pending = append(b.pending, ev)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+	return outcomeHeld
+}
+
+// prune forgets the jobs that notified before the job interval.
+func (b *batcher) prune(now time.Time) {
+	for key, last := range b.lastNotify {
+		if now.Sub(last) >= b.jobInterval {
+			delete(b.lastNotify, key)
+		}
+	}
+}
+
+func (b *batcher) flush() {
+	defer handlePanic()
+	b.mutex.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.timer = nil
+	b.mutex.Unlock()
+
+	if len(pending) <= b.threshold {
+		for _, ev := range pending {
+			b.release(ev)
+		}
+		return
+	}
+	b.release(newGroupEvent(summarizeEvents(pending), pending))
+}
+
+// summarizeEvents describes events grouped by instance and new status, one line per group,
+// e.g. "7 jobs failed on jenkins-a: job1, job2, …".
+func summarizeEvents(events []*jobEvent) string {
+	type groupKey struct {
+		instance string
+		status   string
+	}
+	groups := make(map[groupKey][]string)
+	var keys []groupKey
+	var instanceLines []string
+	for _, ev := range events {
+		for _, member := range ev.members() {
+			if member.Instance {
+				instanceLines = append(instanceLines, member.Message)
+				continue
+			}
+			key := groupKey{instanceName(member.Job.Jenkins), member.NewStatus}
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], member.Job.Name)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return statusRank[keys[i].status] > statusRank[keys[j].status]
+	})
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		names := groups[key]
+		list := strings.Join(names, ", ")
+		if len(names) > 5 {
+			list = strings.Join(names[:5], ", ") + ", …"
+		}
+		id := "group" + key.status
+		if _, ok := statusRank[key.status]; !ok {
+			id = "group"
+		}
+		lines[i] = notifyMessages().format(id, messageData{
+			Count:    len(names),
+			Instance: key.instance,
+			Jobs:     list,
+			Status:   key.status,
+		})
+	}
+	return strings.Join(append(instanceLines, lines...), "\n")
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+// claim marks a broken job as being taken care of. Claims are stored in Jenkins if the Claim
+// plugin is active for the job, otherwise locally in the "Claims" setting.
+type claim struct {
+	Name     string
+	Instance string
+	By       string
+	Comment  string    `json:",omitempty"`
+	Since    time.Time `json:",omitempty"`
+	// Remote is set for claims read from the Claim plugin.
+	Remote bool `json:"-"`
+}
+
+// claimList holds the local claims.
+type claimList struct {
+	mutex  sync.Mutex
+	claims []*claim
+}
+
+func loadClaims() *claimList {
+	list := new(claimList)
+	settings := walk.App().Settings()
+	claimsStr, ok := settings.Get("Claims")
+	if !ok || claimsStr == "" {
+		return list
+	}
+	err := json.Unmarshal([]byte(claimsStr), &list.claims)
+	if err != nil {
+		log.Println("loadClaims:", err)
+	}
+	return list
+}
+
+// save writes the claims to the settings. The caller must hold the mutex.
+func (l *claimList) save() {
+	settings := walk.App().Settings()
+	if len(l.claims) == 0 {
+		settings.Remove("Claims")
+		return
+	}
+	claimsJSON, _ := json.Marshal(l.claims)
+	settings.Put("Claims", string(claimsJSON))
+}
+
+func (l *claimList) find(j *job) int {
+	for i, c := range l.claims {
+		if c.Name == j.Name && c.Instance == j.Jenkins {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *claimList) add(c *claim) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for i, existing := range l.claims {
+		if existing.Name == c.Name && existing.Instance == c.Instance {
+			l.claims[i] = c
+			l.save()
+			return
+		}
+	}
+	l.claims = append(l.claims, c)
+	l.save()
+}
+
+func (l *claimList) remove(j *job) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if idx := l.find(j); idx >= 0 {
+		l.claims = append(l.claims[:idx], l.claims[idx+1:]...)
+		l.save()
+	}
+}
+
+// claimOf returns the current claim of the job. A claim in Jenkins wins over a local one,
+// local claims are dropped once the job is not broken anymore.
+func (l *claimList) claimOf(j *job) *claim {
+	if j.LastCompletedBuild.ClaimedBy != "" {
+		return &claim{
+			Name:     j.Name,
+			Instance: j.Jenkins,
+			By:       j.LastCompletedBuild.ClaimedBy,
+			Comment:  j.LastCompletedBuild.ClaimReason,
+			Remote:   true,
+		}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	idx := l.find(j)
+	if idx < 0 {
+		return nil
+	}
+	switch j.LastCompletedBuild.Result {
+	case "FAILURE", "UNSTABLE", "":
+		return l.claims[idx]
+	}
+	l.claims = append(l.claims[:idx], l.claims[idx+1:]...)
+	l.save()
+	log.Println(j.Name, "claim released, job is", j.LastCompletedBuild.Result)
+	return nil
+}
+
+func sameClaim(a, b *claim) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.By == b.By && a.Comment == b.Comment && a.Remote == b.Remote
+}
+
+// claimJob claims the job with an optional comment, in Jenkins if possible.
+func (m *jobModel) claimJob(item *job, comment string) {
+	defer handlePanic()
+	c := &claim{
+		Name:     item.Name,
+		Instance: item.Jenkins,
+		By:       os.Getenv("USERNAME"),
+		Comment:  comment,
+		Since:    time.Now(),
+	}
+	if item.LastCompletedBuild.Claimable {
+		err := postClaim(item, "claim", url.Values{
+			"reason": {comment},
+			"sticky": {"true"},
+		})
+		if err == nil {
+			c.Remote = true
+		} else {
+			log.Println(item.Name, "claiming in Jenkins failed, claiming locally:", err)
+		}
+	}
+	if !c.Remote {
+		m.claims.add(c)
+		if err := walk.App().Settings().Save(); err != nil {
+			log.Println(err)
+		}
+	}
+	item.Claim = c
+	m.publishJobChanged(item)
+	log.Println(item.Name, "claimed by", c.By)
+}
+
+func (m *jobModel) releaseClaim(item *job) {
+	defer handlePanic()
+	if item.Claim != nil && item.Claim.Remote {
+		if err := postClaim(item, "unclaim", nil); err != nil {
+			log.Println(item.Name, "releasing the claim in Jenkins failed:", err)
+			return
+		}
+	}
+	m.claims.remove(item)
+	if err := walk.App().Settings().Save(); err != nil {
+		log.Println(err)
+	}
+	item.Claim = nil
+	m.publishJobChanged(item)
+	log.Println(item.Name, "claim released")
+}
+
+// publishJobChanged updates the row of the job if it is still shown. The rows may have moved
+// while the job was claimed.
+func (m *jobModel) publishJobChanged(item *job) {
+	for idx, shown := range m.items {
+		if shown == item {
+			m.PublishRowChanged(idx)
+			return
+		}
+	}
+}
+
+// jenkinsRoot derives the root URL of the Jenkins instance from a job URL.
+func jenkinsRoot(jobURL string) string {
+	if idx := strings.Index(jobURL, "/job/"); idx >= 0 {
+		return jobURL[:idx+1]
+	}
+	return jobURL
+}
+
+type crumb struct {
+	Crumb             string `json:"crumb"`
+	CrumbRequestField string `json:"crumbRequestField"`
+}
+
+// getCrumb fetches the CSRF protection token. It returns nil if the instance does not
+// issue crumbs.
+func getCrumb(client *http.Client, root string) (*crumb, error) {
+	resp, err := client.Get(root + "crumbIssuer/api/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("crumb request was not OK: %d", resp.StatusCode)
+	}
+	var c crumb
+	err = json.NewDecoder(resp.Body).Decode(&c)
+	return &c, err
+}
+
+// postClaim calls an action of the Claim plugin on the last completed build of the job.
+func postClaim(j *job, action string, form url.Values) error {
+	if j.LastCompletedBuild.Label == 0 {
+		return errors.New("job has no completed build")
+	}
+	client := clientFor(j.Jenkins)
+	c, err := getCrumb(client, jenkinsRoot(j.URL))
+	if err != nil {
+		return err
+	}
+	if form == nil {
+		form = url.Values{}
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprint(strings.TrimSuffix(j.URL, "/"), "/", j.LastCompletedBuild.Label, "/claim/", action),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c != nil {
+		req.Header.Set(c.CrumbRequestField, c.Crumb)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// the plugin redirects back to the build page
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusFound {
+		return fmt.Errorf("response was not OK: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// openClaimDialog asks for a comment and claims the current job.
+func (mw *jenkinsMainWindow) openClaimDialog() {
+	defer handlePanic()
+	idx := mw.table.CurrentIndex()
+	if idx < 0 {
+		return
+	}
+	model := mw.table.Model().(*jobModel)
+	item := model.items[idx]
+
+	var dlg *walk.Dialog
+	var commentBox *walk.LineEdit
+	var okPB, cancelPB *walk.PushButton
+	err := Dialog{
+		AssignTo:      &dlg,
+		Title:         "Claim " + item.Name,
+		Icon:          mw.Icon(),
+		DefaultButton: &okPB,
+		CancelButton:  &cancelPB,
+		MinSize:       Size{Width: 400},
+		Layout:        VBox{},
+		Children: []Widget{
+			Label{Text: "Comment (optional):"},
+			LineEdit{AssignTo: &commentBox},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &okPB,
+						Text:     "Claim",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdOK)
+						},
+					},
+					PushButton{
+						AssignTo: &cancelPB,
+						Text:     "Cancel",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdCancel)
+						},
+					},
+				},
+			},
+		},
+	}.Create(mw)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if dlg.Run() != walk.DlgCmdOK {
+		return
+	}
+	go model.claimJob(item, commentBox.Text())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"JenkinsCheck/config"
+	"JenkinsCheck/httpclient"
+
+	"github.com/lxn/walk"
+)
+
+// instanceClients holds the HTTP clients by instance URL.
+var instanceClients atomic.Value
+
+// failingTransport fails all requests of an instance with invalid TLS or proxy settings.
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// setupClients builds the HTTP clients of the instances and closes the idle connections of
+// the previous ones. It returns the URLs of the instances not verifying certificates.
+func setupClients(cfg *config.Config) []string {
+	var insecure []string
+	clients := make(map[string]*http.Client, len(cfg.Instances))
+	for _, instance := range cfg.Instances {
+		var base http.RoundTripper
+		transport, err := httpclient.NewTransport(instance)
+		if err != nil {
+			log.Println(instance.URL, "HTTP settings:", err)
+			base = failingTransport{err: err}
+		} else {
+			base = transport
+		}
+		if instance.Insecure {
+			insecure = append(insecure, instance.URL)
+		}
+		clients[instance.URL] = &http.Client{
+			Transport: &authTransport{base: base},
+			Timeout:   httpclient.Timeout(instance),
+		}
+	}
+	old, _ := instanceClients.Load().(map[string]*http.Client)
+	instanceClients.Store(clients)
+	for _, client := range old {
+		client.CloseIdleConnections()
+	}
+	return insecure
+}
+
+// warnInsecure reports instances whose certificates are not verified as loudly as possible.
+func warnInsecure(ni *walk.NotifyIcon, urls []string) {
+	for _, u := range urls {
+		log.Println("WARNING: the certificate of", u, "is not verified, connections to it can be intercepted")
+		if ni != nil {
+			ni.ShowWarning(walk.App().ProductName(), "The certificate of "+u+" is not verified. "+
+				"Connections to it can be intercepted.")
+		}
+	}
+}
+
+// clientFor returns the HTTP client of the instance rawURL belongs to, for other URLs the
+// default client.
+func clientFor(rawURL string) *http.Client {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return http.DefaultClient
+	}
+	instance, ok := instanceFor(u)
+	if !ok {
+		return http.DefaultClient
+	}
+	clients, _ := instanceClients.Load().(map[string]*http.Client)
+	if client, ok := clients[instance.URL]; ok {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// instanceFor returns the instance on the same host as u whose URL shares the longest path
+// with it.
+func instanceFor(u *url.URL) (config.Instance, bool) {
+	var best config.Instance
+	bestLen := -1
+	for _, instance := range getConfig().Instances {
+		iu, err := url.Parse(instance.URL)
+		if err != nil || iu.Scheme != u.Scheme || iu.Host != u.Host {
+			continue
+		}
+		if n := commonPrefix(iu.Path, u.Path); n > bestLen {
+			best, bestLen = instance, n
+		}
+	}
+	return best, bestLen >= 0
+}
+
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
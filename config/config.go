@@ -0,0 +1,436 @@
+// Package config provides typed access to the settings of JenkinsCheck. It does not depend
+// on the GUI, the settings are read from and written to a Storage.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Storage is a flat store of single line string values. walk.IniFileSettings implements it.
+type Storage interface {
+	Get(key string) (string, bool)
+	Put(key, value string) error
+	Remove(key string) error
+	Save() error
+}
+
+// Keys of the settings.
+const (
+	KeyJobs                 = "Jobs"
+	KeyURLPrefix            = "URL_"
+	KeyInterval             = "Interval"
+	KeyMinInterval          = "MinInterval"
+	KeyMaxInterval          = "MaxInterval"
+	KeySuccessiveSuccessful = "Successive_successful"
+	KeyBrowser              = "Browser"
+	KeyInstances            = "Instances"
+	KeyGroups               = "Groups"
+	KeySubscription         = "Subscription"
+	KeyCredentialHelper     = "CredentialHelper"
+	KeyNetrc                = "Netrc"
+
+	// keyCCURL is the single URL stored by old versions.
+	keyCCURL = "CC_URL"
+)
+
+// Defaults used for missing or invalid settings.
+const (
+	DefaultInterval = 30
+	DefaultBrowser  = "explorer.exe"
+)
+
+// DefaultURLs are monitored if no URL is configured.
+var DefaultURLs = []string{"http://hudson.pdv.lan/", "http://hudson.pdv.lan:8090/view/All%20Flat"}
+
+// Instance is a Jenkins view to poll. The URLs are stored in the keys URL_0 to URL_n, the
+// other fields in the "Instances" setting.
+type Instance struct {
+	URL string
+	// Name is a short name to refer to the instance in the config file.
+	Name string `json:",omitempty"`
+	// Credential is the id of the credential used to access the instance.
+	Credential string `json:",omitempty"`
+	// CABundle is a PEM file with the certificates trusted in addition to the system ones.
+	CABundle string `json:",omitempty"`
+	// ClientCert and ClientKey are PEM files authenticating JenkinsCheck by mutual TLS.
+	ClientCert string `json:",omitempty"`
+	ClientKey  string `json:",omitempty"`
+	// Insecure skips the verification of the server certificate.
+	Insecure bool `json:",omitempty"`
+	// Proxy is the URL of the proxy or ProxyNone. Empty uses the proxy of the environment.
+	Proxy string `json:",omitempty"`
+	// Timeouts in seconds. Zero uses the defaults.
+	ConnectTimeout  int `json:",omitempty"`
+	ResponseTimeout int `json:",omitempty"`
+	Timeout         int `json:",omitempty"`
+}
+
+// ProxyNone connects to an instance without proxy.
+const ProxyNone = "none"
+
+// Job is a monitored job.
+type Job struct {
+	Name string
+	// Instance is the URL of the Jenkins view the job belongs to.
+	Instance string
+	// Groups the job is tagged with.
+	Groups []string `json:",omitempty"`
+}
+
+// Group collects jobs to be shown and notified about together.
+type Group struct {
+	Name string
+	// Jobs are regular expressions of job names that belong to the group in addition to the
+	// jobs tagged with it.
+	Jobs []string `json:",omitempty"`
+	// Notify are the ids of the messages to notify about, e.g. "failed". Empty notifies
+	// about all.
+	Notify []string `json:",omitempty"`
+	// Sinks are the names of the notifiers to deliver to, e.g. "balloon" or "mail". Empty
+	// delivers to all.
+	Sinks []string `json:",omitempty"`
+	// Muted suppresses the notifications of the group's jobs.
+	Muted bool `json:",omitempty"`
+	// Interval between two polls of the group's jobs in seconds. Zero uses the global one.
+	Interval int `json:",omitempty"`
+}
+
+// Config holds all settings with defaults applied.
+type Config struct {
+	Instances []Instance
+	Jobs      []Job
+	Groups    []Group
+	// Interval between two polls in seconds.
+	Interval int
+	// MinInterval and MaxInterval limit the interval adapted to the activity and load of an
+	// instance in seconds. Zero uses a quarter of the interval, but at least 5 seconds, and ten
+	// times the interval.
+	MinInterval int
+	MaxInterval int
+	// SuccessiveSuccessful notifies about every successful build, not only fixed ones.
+	SuccessiveSuccessful bool
+	// Browser is the executable links are opened with. Empty means the default browser.
+	Browser string
+	// CredentialHelper is a command line speaking the git credential protocol that provides
+	// the credentials of instances without a stored credential.
+	CredentialHelper string
+	// Netrc is the path of the .netrc file read for instances without a stored credential.
+	// Empty means the default location.
+	Netrc string
+	// Subscription is the shared watch list the jobs are merged with.
+	Subscription Subscription
+	// JobRules select jobs in addition to Jobs.
+	JobRules JobRules
+	rules    *RuleSet
+	// groupJobs are the compiled Jobs patterns by group name.
+	groupJobs map[string][]*regexp.Regexp
+}
+
+// Default returns the configuration used without any settings.
+func Default() *Config {
+	c := &Config{
+		Jobs:     []Job{},
+		Interval: DefaultInterval,
+		Subscription: Subscription{
+			Interval: DefaultSubscriptionInterval,
+		},
+	}
+	for _, u := range DefaultURLs {
+		c.Instances = append(c.Instances, Instance{URL: u})
+	}
+	return c
+}
+
+// URLs returns the URLs of all instances.
+func (c *Config) URLs() []string {
+	urls := make([]string, len(c.Instances))
+	for i, instance := range c.Instances {
+		urls[i] = instance.URL
+	}
+	return urls
+}
+
+// Instance returns the instance with the given name or URL.
+func (c *Config) Instance(nameOrURL string) (Instance, bool) {
+	for _, instance := range c.Instances {
+		if instance.URL == nameOrURL || instance.Name != "" && instance.Name == nameOrURL {
+			return instance, true
+		}
+	}
+	return Instance{}, false
+}
+
+// BrowserCommand returns the executable to open links with.
+func (c *Config) BrowserCommand() string {
+	if c.Browser == "" {
+		return DefaultBrowser
+	}
+	return c.Browser
+}
+
+// FieldError describes an invalid setting.
+type FieldError struct {
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %q: %v", e.Key, e.Value, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Errors lists all invalid settings found by Load.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Of returns the errors of the keys. A key ending in "_" matches all keys with this prefix.
+func (e Errors) Of(keys ...string) Errors {
+	var of Errors
+	for _, err := range e {
+		for _, key := range keys {
+			if err.Key == key || strings.HasSuffix(key, "_") && strings.HasPrefix(err.Key, key) {
+				of = append(of, err)
+				break
+			}
+		}
+	}
+	return of
+}
+
+// Load reads the configuration from s. Invalid settings are replaced by their defaults and
+// reported together as Errors, so the returned Config is always usable.
+func Load(s Storage) (*Config, error) {
+	c := Default()
+	var errs Errors
+	invalid := func(key, value string, err error) {
+		errs = append(errs, &FieldError{Key: key, Value: value, Err: err})
+	}
+
+	if value, ok := get(s, KeyInterval); ok {
+		interval, err := strconv.Atoi(value)
+		switch {
+		case err != nil:
+			invalid(KeyInterval, value, errors.New("not a number of seconds"))
+		case interval < 1:
+			invalid(KeyInterval, value, errors.New("must be at least 1 second"))
+		default:
+			c.Interval = interval
+		}
+	}
+	if value, ok := get(s, KeyMinInterval); ok {
+		interval, err := strconv.Atoi(value)
+		switch {
+		case err != nil:
+			invalid(KeyMinInterval, value, errors.New("not a number of seconds"))
+		case interval < 1:
+			invalid(KeyMinInterval, value, errors.New("must be at least 1 second"))
+		case interval > c.Interval:
+			invalid(KeyMinInterval, value, fmt.Errorf("must not be longer than the interval of %d seconds", c.Interval))
+		default:
+			c.MinInterval = interval
+		}
+	}
+	if value, ok := get(s, KeyMaxInterval); ok {
+		interval, err := strconv.Atoi(value)
+		switch {
+		case err != nil:
+			invalid(KeyMaxInterval, value, errors.New("not a number of seconds"))
+		case interval < c.Interval:
+			invalid(KeyMaxInterval, value, fmt.Errorf("must not be shorter than the interval of %d seconds", c.Interval))
+		default:
+			c.MaxInterval = interval
+		}
+	}
+
+	if value, ok := get(s, KeySuccessiveSuccessful); ok {
+		ssBuilds, err := strconv.ParseBool(value)
+		if err != nil {
+			invalid(KeySuccessiveSuccessful, value, errors.New("must be true or false"))
+		}
+		c.SuccessiveSuccessful = ssBuilds
+	}
+
+	c.Browser, _ = get(s, KeyBrowser)
+	c.CredentialHelper, _ = get(s, KeyCredentialHelper)
+	c.Netrc, _ = get(s, KeyNetrc)
+
+	var instances []Instance
+	if value, ok := get(s, KeyInstances); ok {
+		if err := json.Unmarshal([]byte(value), &instances); err != nil {
+			invalid(KeyInstances, value, err)
+		}
+	}
+	var urls []Instance
+	for i := 0; ; i++ {
+		key := KeyURLPrefix + strconv.Itoa(i)
+		value, ok := s.Get(key)
+		if !ok {
+			break
+		}
+		if err := validateURL(value); err != nil {
+			invalid(key, value, err)
+			// passwords of old versions authenticate until the migration moved them
+			if err != ErrURLPassword || migrated(s, migrationURLCredentials) {
+				continue
+			}
+		}
+		instance := Instance{URL: value}
+		for _, other := range instances {
+			if other.URL == value {
+				instance = other
+				break
+			}
+		}
+		// invalid HTTP settings are kept, the requests to the instance report the error
+		if err := instance.Validate(); err != nil {
+			invalid(KeyInstances, instance.URL, err)
+		}
+		urls = append(urls, instance)
+	}
+	if len(urls) > 0 {
+		c.Instances = urls
+	}
+
+	if value, ok := get(s, KeyJobs); ok {
+		var jobs []Job
+		err := json.Unmarshal([]byte(value), &jobs)
+		if err != nil {
+			// stored by old versions as a list of job names, not migrated yet
+			var names []string
+			if json.Unmarshal([]byte(value), &names) != nil {
+				invalid(KeyJobs, value, err)
+			}
+			jobs = make([]Job, len(names))
+			for i, name := range names {
+				jobs[i] = Job{Name: name}
+			}
+		}
+		if jobs != nil {
+			c.Jobs = jobs
+		}
+	}
+
+	if err := loadGroups(s, c); err != nil {
+		errs = append(errs, err)
+	}
+
+	if value, ok := get(s, KeySubscription); ok {
+		var subscription Subscription
+		err := json.Unmarshal([]byte(value), &subscription)
+		switch {
+		case err != nil:
+			invalid(KeySubscription, value, err)
+		case subscription.Interval < 0:
+			invalid(KeySubscription, value, errors.New("the interval must not be negative"))
+		default:
+			if subscription.Interval == 0 {
+				subscription.Interval = DefaultSubscriptionInterval
+			}
+			c.Subscription = subscription
+		}
+	}
+
+	// after the instances, the rules refer to them
+	if err := loadJobRules(s, c); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+// Validate checks the HTTP settings of the instance.
+func (instance Instance) Validate() error {
+	switch {
+	case (instance.ClientCert == "") != (instance.ClientKey == ""):
+		return errors.New("the client certificate and key must be set together")
+	case instance.ConnectTimeout < 0 || instance.ResponseTimeout < 0 || instance.Timeout < 0:
+		return errors.New("the timeouts must not be negative")
+	case instance.Proxy != "" && instance.Proxy != ProxyNone:
+		if err := validateURL(instance.Proxy); err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+	}
+	return nil
+}
+
+// get returns the trimmed value of key. Empty values count as missing.
+func get(s Storage, key string) (string, bool) {
+	value, ok := s.Get(key)
+	value = strings.TrimSpace(value)
+	return value, ok && value != ""
+}
+
+func validateURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return errors.New("not an absolute http or https URL")
+	}
+	if _, ok := u.User.Password(); u.User != nil && ok {
+		return ErrURLPassword
+	}
+	return nil
+}
+
+// ErrURLPassword reports a URL with a password. Until the migration moved it to a credential
+// the URL is still used.
+var ErrURLPassword = errors.New("contains a password, refer to a credential instead")
+
+// PutURLs replaces the stored URLs.
+func PutURLs(s Storage, urls []string) error {
+	var i int
+	for ; i < len(urls); i++ {
+		if err := s.Put(KeyURLPrefix+strconv.Itoa(i), urls[i]); err != nil {
+			return err
+		}
+	}
+	for ; ; i++ {
+		key := KeyURLPrefix + strconv.Itoa(i)
+		if _, ok := s.Get(key); !ok {
+			return nil
+		}
+		if err := s.Remove(key); err != nil {
+			return err
+		}
+	}
+}
+
+// PutInstances replaces the stored names and credentials of the instances. The URLs are
+// stored by PutURLs.
+func PutInstances(s Storage, instances []Instance) error {
+	return putJSONSetting(s, KeyInstances, instances)
+}
+
+// PutJobs replaces the stored monitored jobs.
+func PutJobs(s Storage, jobs []Job) error {
+	if jobs == nil {
+		jobs = []Job{}
+	}
+	jobsJSON, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+	return s.Put(KeyJobs, string(jobsJSON))
+}
@@ -0,0 +1,437 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FileName is the name of the optional config file next to settings.ini.
+const FileName = "jenkinscheck.yaml"
+
+// notificationKeys maps the sections of the notifications block in the config file to the
+// settings holding them as JSON.
+var notificationKeys = map[string]string{
+	"mail":         "Mail",
+	"execHooks":    "ExecHooks",
+	"mqtt":         "MQTT",
+	"quietHours":   "QuietHours",
+	"pollingHours": "PollingHours",
+	"batching":     "Batching",
+	"escalation":   "Escalation",
+	"messages":     "Messages",
+	"backoff":      "Backoff",
+}
+
+// File is the structure of the config file. Everything it defines takes precedence over
+// settings.ini.
+type File struct {
+	Interval             int            `yaml:"interval,omitempty"`
+	MinInterval          int            `yaml:"minInterval,omitempty"`
+	MaxInterval          int            `yaml:"maxInterval,omitempty"`
+	SuccessiveSuccessful *bool          `yaml:"successiveSuccessful,omitempty"`
+	Browser              string         `yaml:"browser,omitempty"`
+	Language             string         `yaml:"language,omitempty"`
+	CredentialHelper     string         `yaml:"credentialHelper,omitempty"`
+	Netrc                string         `yaml:"netrc,omitempty"`
+	Instances            []FileInstance `yaml:"instances,omitempty"`
+	Jobs                 []FileJob      `yaml:"jobs,omitempty"`
+	Groups               []FileGroup    `yaml:"groups,omitempty"`
+	Subscription         *Subscription  `yaml:"subscription,omitempty"`
+	// Rules select jobs by instance, view and name in addition to the listed jobs.
+	Rules *JobRules `yaml:"rules,omitempty"`
+	// Notifications has the sections mail, execHooks, mqtt, quietHours, pollingHours,
+	// batching, escalation, messages and backoff with the same fields as the settings.
+	Notifications map[string]interface{} `yaml:"notifications,omitempty"`
+}
+
+type FileInstance struct {
+	URL             string `yaml:"url"`
+	Name            string `yaml:"name,omitempty"`
+	Credential      string `yaml:"credential,omitempty"`
+	CABundle        string `yaml:"caBundle,omitempty"`
+	ClientCert      string `yaml:"clientCert,omitempty"`
+	ClientKey       string `yaml:"clientKey,omitempty"`
+	Insecure        bool   `yaml:"insecure,omitempty"`
+	Proxy           string `yaml:"proxy,omitempty"`
+	ConnectTimeout  int    `yaml:"connectTimeout,omitempty"`
+	ResponseTimeout int    `yaml:"responseTimeout,omitempty"`
+	Timeout         int    `yaml:"timeout,omitempty"`
+}
+
+type FileJob struct {
+	Name string `yaml:"name"`
+	// Instance is the name or URL of the instance.
+	Instance string   `yaml:"instance"`
+	Groups   []string `yaml:"groups,omitempty"`
+}
+
+type FileGroup struct {
+	Name     string   `yaml:"name"`
+	Jobs     []string `yaml:"jobs,omitempty"`
+	Notify   []string `yaml:"notify,omitempty"`
+	Sinks    []string `yaml:"sinks,omitempty"`
+	Muted    bool     `yaml:"muted,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+// ReadFile parses the config file at path.
+func ReadFile(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := new(File)
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// WriteFile writes f to path.
+func (f *File) WriteFile(path string) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Values converts the file to the settings it defines.
+func (f *File) Values() (map[string]string, error) {
+	values := make(map[string]string)
+	if f.Interval != 0 {
+		values[KeyInterval] = strconv.Itoa(f.Interval)
+	}
+	if f.MinInterval != 0 {
+		values[KeyMinInterval] = strconv.Itoa(f.MinInterval)
+	}
+	if f.MaxInterval != 0 {
+		values[KeyMaxInterval] = strconv.Itoa(f.MaxInterval)
+	}
+	if f.SuccessiveSuccessful != nil {
+		values[KeySuccessiveSuccessful] = strconv.FormatBool(*f.SuccessiveSuccessful)
+	}
+	if f.Browser != "" {
+		values[KeyBrowser] = f.Browser
+	}
+	if f.CredentialHelper != "" {
+		values[KeyCredentialHelper] = f.CredentialHelper
+	}
+	if f.Netrc != "" {
+		values[KeyNetrc] = f.Netrc
+	}
+	if f.Language != "" {
+		values["Language"] = f.Language
+	}
+
+	if len(f.Instances) > 0 {
+		instances := make([]Instance, len(f.Instances))
+		for i, instance := range f.Instances {
+			if instance.URL == "" {
+				return nil, fmt.Errorf("instance %d has no url", i+1)
+			}
+			values[KeyURLPrefix+strconv.Itoa(i)] = instance.URL
+			instances[i] = Instance(instance)
+		}
+		if err := putJSON(values, KeyInstances, instances); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Jobs != nil {
+		jobs := make([]Job, len(f.Jobs))
+		for i, j := range f.Jobs {
+			jobs[i] = Job{Name: j.Name, Instance: j.Instance, Groups: j.Groups}
+			for _, instance := range f.Instances {
+				if instance.Name != "" && instance.Name == j.Instance {
+					jobs[i].Instance = instance.URL
+					break
+				}
+			}
+		}
+		if err := putJSON(values, KeyJobs, jobs); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Groups != nil {
+		groups := make([]Group, len(f.Groups))
+		for i, g := range f.Groups {
+			groups[i] = Group(g)
+		}
+		if err := putJSON(values, KeyGroups, groups); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Subscription != nil {
+		if err := putJSON(values, KeySubscription, f.Subscription); err != nil {
+			return nil, err
+		}
+	}
+	if f.Rules != nil {
+		if err := putJSON(values, KeyJobRules, f.Rules); err != nil {
+			return nil, err
+		}
+	}
+
+	for section, value := range f.Notifications {
+		key, ok := notificationKeys[section]
+		if !ok {
+			return nil, fmt.Errorf("unknown notifications section %q", section)
+		}
+		if err := putJSON(values, key, jsonValue(value)); err != nil {
+			return nil, fmt.Errorf("notifications section %q: %w", section, err)
+		}
+	}
+	return values, nil
+}
+
+func putJSON(values map[string]string, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	values[key] = string(data)
+	return nil
+}
+
+// jsonValue converts the maps decoded from YAML to maps that can be encoded as JSON.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+	}
+	return v
+}
+
+// Export builds a config file from the settings in s, so they can be moved to the file in
+// one step. Passwords are left out, credentials are only referred to by id.
+func Export(s Storage) (*File, error) {
+	c, err := Load(s)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{
+		Interval:         c.Interval,
+		MinInterval:      c.MinInterval,
+		MaxInterval:      c.MaxInterval,
+		Browser:          c.Browser,
+		CredentialHelper: c.CredentialHelper,
+		Netrc:            c.Netrc,
+	}
+	if c.SuccessiveSuccessful {
+		f.SuccessiveSuccessful = &c.SuccessiveSuccessful
+	}
+	f.Language, _ = get(s, "Language")
+	for _, instance := range c.Instances {
+		f.Instances = append(f.Instances, FileInstance(instance))
+	}
+	for _, j := range c.Jobs {
+		instance := j.Instance
+		if i, ok := c.Instance(instance); ok && i.Name != "" {
+			instance = i.Name
+		}
+		f.Jobs = append(f.Jobs, FileJob{Name: j.Name, Instance: instance, Groups: j.Groups})
+	}
+	for _, g := range c.Groups {
+		f.Groups = append(f.Groups, FileGroup(g))
+	}
+	if c.Subscription.Source != "" {
+		f.Subscription = &c.Subscription
+	}
+	if len(c.JobRules.Include) > 0 || len(c.JobRules.Exclude) > 0 {
+		f.Rules = &c.JobRules
+	}
+
+	sections := make([]string, 0, len(notificationKeys))
+	for section := range notificationKeys {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		key := notificationKeys[section]
+		value, ok := get(s, key)
+		if !ok {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, &FieldError{Key: key, Value: value, Err: err}
+		}
+		if f.Notifications == nil {
+			f.Notifications = make(map[string]interface{})
+		}
+		if section == "messages" {
+			// the keys are message ids
+			f.Notifications[section] = v
+		} else {
+			f.Notifications[section] = lowerKeys(withoutPasswords(v))
+		}
+	}
+	return f, nil
+}
+
+// secretKeys are parts of the names of notifier settings that are not exported.
+var secretKeys = []string{"password", "token", "secret"}
+
+// withoutPasswords removes the passwords and other secrets of the notifiers.
+func withoutPasswords(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSecretKey(key) {
+				delete(v, key)
+				continue
+			}
+			withoutPasswords(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			withoutPasswords(value)
+		}
+	}
+	return v
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerKeys starts all object keys with a lower case letter to match the style of the
+// file. The settings are decoded case insensitively, so the keys still match.
+func lowerKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			r, size := utf8.DecodeRuneInString(key)
+			m[string(unicode.ToLower(r))+key[size:]] = lowerKeys(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = lowerKeys(value)
+		}
+	}
+	return v
+}
+
+// Overlay holds the values of the config file which take precedence over the other
+// settings.
+type Overlay struct {
+	mutex  sync.RWMutex
+	values map[string]string
+}
+
+// Get returns the value of key if it is defined by the config file.
+func (o *Overlay) Get(key string) (string, bool) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	value, ok := o.values[key]
+	return value, ok
+}
+
+// Hides reports whether the config file replaces key although it does not define it: the
+// file defines instances and key is a URL beyond them.
+func (o *Overlay) Hides(key string) bool {
+	if !strings.HasPrefix(key, KeyURLPrefix) {
+		return false
+	}
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	if _, ok := o.values[key]; ok {
+		return false
+	}
+	_, ok := o.values[KeyURLPrefix+"0"]
+	return ok
+}
+
+// Defines reports whether the config file defines any of the keys.
+func (o *Overlay) Defines(keys ...string) bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	for _, key := range keys {
+		if _, ok := o.values[key]; ok {
+			return true
+		}
+		if strings.HasSuffix(key, "_") {
+			if _, ok := o.values[key+"0"]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Set replaces the values of the config file.
+func (o *Overlay) Set(values map[string]string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.values = values
+}
+
+// ReadValues returns the settings defined by the config file at path. A missing file
+// defines none.
+func ReadValues(path string) (map[string]string, error) {
+	f, err := ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.Values()
+}
+
+// Watch checks the file at path for changes every interval and calls changed with its new
+// values, see ReadValues. Watch returns once stop is closed.
+func Watch(path string, interval time.Duration, stop <-chan struct{}, changed func(map[string]string, error)) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var current time.Time
+			if info, err := os.Stat(path); err == nil {
+				current = info.ModTime()
+			}
+			if current.Equal(modTime) {
+				continue
+			}
+			modTime = current
+			changed(ReadValues(path))
+		case <-stop:
+			return
+		}
+	}
+}
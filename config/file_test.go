@@ -0,0 +1,154 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	s := newMemStorage(map[string]string{
+		KeyInterval:        "60",
+		KeyURLPrefix + "0": "http://jenkins-a/",
+		KeyURLPrefix + "1": "http://jenkins-b/",
+		KeyInstances:       `[{"URL":"http://jenkins-b/","Name":"b","Credential":"c1"}]`,
+		KeyJobs:            `[{"Name":"build","Instance":"http://jenkins-a/"},{"Name":"deploy","Instance":"http://jenkins-b/"}]`,
+		"Mail":             `{"Host":"smtp.example.com","Username":"jc","Password":"hunter2","Credential":"c2","Recipients":[{"Address":"a@example.com","Token":"t"}]}`,
+		"MQTT":             `{"Broker":"tcp://broker:1883","Password":"hunter2","ClientSecret":"s"}`,
+		"Messages":         `{"Password":"Password of {{.Name}}"}`,
+	})
+	f, err := Export(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Interval != 60 {
+		t.Errorf("Interval = %d, want 60", f.Interval)
+	}
+	wantInstances := []FileInstance{
+		{URL: "http://jenkins-a/"},
+		{URL: "http://jenkins-b/", Name: "b", Credential: "c1"},
+	}
+	if !reflect.DeepEqual(f.Instances, wantInstances) {
+		t.Errorf("Instances = %+v, want %+v", f.Instances, wantInstances)
+	}
+	// jobs refer to named instances by name
+	wantJobs := []FileJob{{Name: "build", Instance: "http://jenkins-a/"}, {Name: "deploy", Instance: "b"}}
+	if !reflect.DeepEqual(f.Jobs, wantJobs) {
+		t.Errorf("Jobs = %+v, want %+v", f.Jobs, wantJobs)
+	}
+	wantMail := map[string]interface{}{
+		"host":       "smtp.example.com",
+		"username":   "jc",
+		"credential": "c2",
+		"recipients": []interface{}{map[string]interface{}{"address": "a@example.com"}},
+	}
+	if !reflect.DeepEqual(f.Notifications["mail"], wantMail) {
+		t.Errorf("mail = %v, want %v", f.Notifications["mail"], wantMail)
+	}
+	wantMQTT := map[string]interface{}{"broker": "tcp://broker:1883"}
+	if !reflect.DeepEqual(f.Notifications["mqtt"], wantMQTT) {
+		t.Errorf("mqtt = %v, want %v", f.Notifications["mqtt"], wantMQTT)
+	}
+	// message ids are no secrets
+	wantMessages := map[string]interface{}{"Password": "Password of {{.Name}}"}
+	if !reflect.DeepEqual(f.Notifications["messages"], wantMessages) {
+		t.Errorf("messages = %v, want %v", f.Notifications["messages"], wantMessages)
+	}
+}
+
+func TestExportInvalid(t *testing.T) {
+	_, err := Export(newMemStorage(map[string]string{KeyInterval: "never"}))
+	if err == nil {
+		t.Error("Export accepted invalid settings")
+	}
+}
+
+// TestExportRoundTrip writes the exported file and reads it back as settings.
+func TestExportRoundTrip(t *testing.T) {
+	s := newMemStorage(map[string]string{
+		KeyInterval:             "45",
+		KeySuccessiveSuccessful: "true",
+		KeyURLPrefix + "0":      "http://jenkins-a/",
+		KeyInstances:            `[{"URL":"http://jenkins-a/","Name":"a","Insecure":true}]`,
+		KeyJobs:                 `[{"Name":"build","Instance":"http://jenkins-a/","Groups":["nightly"]}]`,
+		KeyGroups:               `[{"Name":"nightly","Jobs":["^nightly-"],"Muted":true}]`,
+		"Batching":              `{"Window":60}`,
+	})
+	f, err := Export(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, FileName)
+	if err := f.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	values, err := ReadValues(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := Load(s)
+	got, err := Load(newMemStorage(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Instances, want.Instances) || !reflect.DeepEqual(got.Jobs, want.Jobs) ||
+		!reflect.DeepEqual(got.Groups, want.Groups) || got.Interval != want.Interval ||
+		got.SuccessiveSuccessful != want.SuccessiveSuccessful {
+		t.Errorf("read back %+v, want %+v", got, want)
+	}
+	if values["Batching"] != `{"window":60}` {
+		t.Errorf("Batching = %s", values["Batching"])
+	}
+}
+
+func TestReadFileStrict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, FileName)
+	if err := ioutil.WriteFile(path, []byte("interval: 30\nintervall: 60\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err == nil || !strings.Contains(err.Error(), "intervall") {
+		t.Errorf("ReadFile = %v, want an error about the unknown field", err)
+	}
+	if values, err := ReadValues(filepath.Join(dir, "missing.yaml")); err != nil || values != nil {
+		t.Errorf("ReadValues of a missing file = %v, %v", values, err)
+	}
+}
+
+func TestOverlayHides(t *testing.T) {
+	var o Overlay
+	if o.Hides(KeyURLPrefix + "0") {
+		t.Error("an empty overlay hides URL_0")
+	}
+	o.Set(map[string]string{KeyURLPrefix + "0": "http://jenkins-a/", KeyInterval: "60"})
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{KeyURLPrefix + "0", false},
+		{KeyURLPrefix + "1", true},
+		{KeyURLPrefix + "7", true},
+		{KeyInterval, false},
+		{KeyJobs, false},
+	}
+	for _, test := range tests {
+		if got := o.Hides(test.key); got != test.want {
+			t.Errorf("Hides(%s) = %v, want %v", test.key, got, test.want)
+		}
+	}
+	if !o.Defines(KeyURLPrefix) || o.Defines(KeyJobs) {
+		t.Error("Defines does not match the values")
+	}
+}
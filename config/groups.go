@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// GroupSettings are the combined settings of the groups of a job.
+type GroupSettings struct {
+	// Groups are the names of the groups, the first one is shown for the job.
+	Groups []string
+	// Notify are the message ids to notify about. Nil notifies about all.
+	Notify []string
+	// Sinks are the notifiers to deliver to. Nil delivers to all.
+	Sinks []string
+	// Muted is set if one of the groups is muted.
+	Muted bool
+	// Interval is the shortest poll interval of the groups, zero if none sets one.
+	Interval int
+}
+
+// Notifies reports whether the message with the given id is sent.
+func (g *GroupSettings) Notifies(id string) bool {
+	return g.Notify == nil || contains(g.Notify, id)
+}
+
+// DeliversTo reports whether the notifier with the given name receives the notifications.
+func (g *GroupSettings) DeliversTo(sink string) bool {
+	return g.Sinks == nil || contains(g.Sinks, sink)
+}
+
+// Group returns the group with the given name.
+func (c *Config) Group(name string) (Group, bool) {
+	for _, g := range c.Groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return Group{}, false
+}
+
+// GroupsOf returns the names of the groups of a job: the defined groups it is tagged with or
+// whose patterns match, followed by tags without a defined group.
+func (c *Config) GroupsOf(instance, name string) []string {
+	var tags []string
+	for _, j := range c.Jobs {
+		if j.Name == name && j.Instance == instance {
+			tags = j.Groups
+			break
+		}
+	}
+	var groups []string
+	for _, g := range c.Groups {
+		if contains(tags, g.Name) || matchesAny(c.groupJobs[g.Name], name) {
+			groups = append(groups, g.Name)
+		}
+	}
+	for _, tag := range tags {
+		if !contains(groups, tag) {
+			groups = append(groups, tag)
+		}
+	}
+	return groups
+}
+
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupSettings returns the combined settings of the groups of a job. A group without
+// Notify or Sinks does not restrict the notifications of its jobs.
+func (c *Config) GroupSettings(instance, name string) GroupSettings {
+	settings := GroupSettings{Groups: c.GroupsOf(instance, name)}
+	restricted := len(settings.Groups) > 0
+	var notify, sinks []string
+	allNotify, allSinks := !restricted, !restricted
+	for _, groupName := range settings.Groups {
+		g, ok := c.Group(groupName)
+		if !ok {
+			allNotify, allSinks = true, true
+			continue
+		}
+		settings.Muted = settings.Muted || g.Muted
+		if g.Interval > 0 && (settings.Interval == 0 || g.Interval < settings.Interval) {
+			settings.Interval = g.Interval
+		}
+		if len(g.Notify) == 0 {
+			allNotify = true
+		}
+		notify = append(notify, g.Notify...)
+		if len(g.Sinks) == 0 {
+			allSinks = true
+		}
+		sinks = append(sinks, g.Sinks...)
+	}
+	if !allNotify {
+		settings.Notify = notify
+	}
+	if !allSinks {
+		settings.Sinks = sinks
+	}
+	return settings
+}
+
+// minIntervalFloor is the shortest default minimum interval in seconds.
+const minIntervalFloor = 5
+
+// IntervalLimits returns the shortest and the longest interval between two polls of an
+// instance in seconds.
+func (c *Config) IntervalLimits() (min, max int) {
+	min, max = c.MinInterval, c.MaxInterval
+	if min == 0 {
+		min = c.Interval / 4
+		if min < minIntervalFloor {
+			min = minIntervalFloor
+		}
+		if min > c.Interval {
+			min = c.Interval
+		}
+	}
+	if max == 0 {
+		max = 10 * c.Interval
+	}
+	return min, max
+}
+
+// PollInterval returns the time between two polls in seconds: the shortest of the minimum
+// and the group intervals.
+func (c *Config) PollInterval() int {
+	interval, _ := c.IntervalLimits()
+	for _, g := range c.Groups {
+		if g.Interval > 0 && g.Interval < interval {
+			interval = g.Interval
+		}
+	}
+	return interval
+}
+
+// PutGroups replaces the stored groups.
+func PutGroups(s Storage, groups []Group) error {
+	if len(groups) == 0 {
+		return s.Remove(KeyGroups)
+	}
+	return putJSONSetting(s, KeyGroups, groups)
+}
+
+func loadGroups(s Storage, c *Config) *FieldError {
+	value, ok := get(s, KeyGroups)
+	if !ok {
+		return nil
+	}
+	var groups []Group
+	if err := json.Unmarshal([]byte(value), &groups); err != nil {
+		return &FieldError{Key: KeyGroups, Value: value, Err: err}
+	}
+	compiled := make(map[string][]*regexp.Regexp, len(groups))
+	for i, g := range groups {
+		switch {
+		case g.Name == "":
+			return &FieldError{Key: KeyGroups, Value: value, Err: fmt.Errorf("group %d has no name", i+1)}
+		case compiled[g.Name] != nil:
+			return &FieldError{Key: KeyGroups, Value: value, Err: fmt.Errorf("group %q is defined twice", g.Name)}
+		case g.Interval < 0:
+			return &FieldError{Key: KeyGroups, Value: value, Err: fmt.Errorf("group %q: the interval must not be negative", g.Name)}
+		}
+		patterns := make([]*regexp.Regexp, 0, len(g.Jobs))
+		for _, pattern := range g.Jobs {
+			regex, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return &FieldError{Key: KeyGroups, Value: value, Err: fmt.Errorf("group %q: %w", g.Name, err)}
+			}
+			patterns = append(patterns, regex)
+		}
+		compiled[g.Name] = patterns
+	}
+	c.Groups = groups
+	c.groupJobs = compiled
+	return nil
+}
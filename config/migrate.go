@@ -0,0 +1,239 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// KeySchemaVersion holds the version of the settings format.
+const KeySchemaVersion = "SchemaVersion"
+
+// InstanceResolver returns the URLs of the instances of the jobs with the given names. It
+// fails if not all instances could be queried.
+type InstanceResolver func(names []string) (map[string]string, error)
+
+// ErrDeferred is returned by Migrate if a migration needs to query the instances but the
+// environment has no InstanceResolver. The migrations from there on are run by a later call.
+var ErrDeferred = errors.New("migration deferred until the instances can be queried")
+
+// MigrationEnv provides what migrations need beyond the settings.
+type MigrationEnv struct {
+	// ResolveInstances is nil if the instances cannot be queried yet.
+	ResolveInstances InstanceResolver
+	// StoreCredential saves a credential and returns its id.
+	StoreCredential func(user, token string) (string, error)
+}
+
+// Migration converts the settings from the previous version to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(s Storage, env *MigrationEnv) error
+}
+
+// Migrations are all conversions in the order they are run. New formats append a migration,
+// its version is the schema version written from then on.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "single CC_URL to numbered URLs",
+		Apply:       migrateCCURL,
+	},
+	{
+		Version:     2,
+		Description: "job names to jobs with their instance",
+		Apply:       migrateJobNames,
+	},
+	{
+		Version:     3,
+		Description: "passwords in URLs to the credential store",
+		Apply:       migrateURLCredentials,
+	},
+}
+
+// migrationURLCredentials is the version moving passwords out of the URLs.
+const migrationURLCredentials = 3
+
+// migrated reports whether the migration to version has run on s.
+func migrated(s Storage, version int) bool {
+	value, ok := get(s, KeySchemaVersion)
+	if !ok {
+		return false
+	}
+	v, err := strconv.Atoi(value)
+	return err == nil && v >= version
+}
+
+// SchemaVersion is the version of the settings written by this version.
+func SchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// Migrate runs the migrations newer than the stored schema version once and returns the ones
+// it applied. Before each one, the settings file at path is copied to path.v<version>.bak.
+// A failed migration stops the run and is retried at the next start.
+func Migrate(s Storage, path string, env *MigrationEnv) ([]Migration, error) {
+	var applied []Migration
+	version := 0
+	if value, ok := get(s, KeySchemaVersion); ok {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, &FieldError{Key: KeySchemaVersion, Value: value, Err: err}
+		}
+		version = v
+	}
+	if version > SchemaVersion() {
+		return nil, fmt.Errorf("the settings have version %d, this version only knows up to %d", version, SchemaVersion())
+	}
+	for _, m := range Migrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := backup(path, version); err != nil {
+			return applied, fmt.Errorf("backup before migration %d: %w", m.Version, err)
+		}
+		if err := m.Apply(s, env); errors.Is(err, ErrDeferred) {
+			return applied, ErrDeferred
+		} else if err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		if err := s.Put(KeySchemaVersion, strconv.Itoa(m.Version)); err != nil {
+			return applied, err
+		}
+		if err := s.Save(); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m)
+		version = m.Version
+	}
+	return applied, nil
+}
+
+// backup copies the settings file unless it does not exist or was backed up before.
+func backup(path string, version int) error {
+	backupPath := path + ".v" + strconv.Itoa(version) + ".bak"
+	if _, err := os.Stat(backupPath); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(backupPath, data, 0644)
+}
+
+// migrateCCURL moves the single URL stored by old versions to the first numbered URL.
+func migrateCCURL(s Storage, _ *MigrationEnv) error {
+	ccURL, ok := s.Get(keyCCURL)
+	if !ok {
+		return nil
+	}
+	if err := s.Remove(keyCCURL); err != nil {
+		return err
+	}
+	return s.Put(KeyURLPrefix+"0", ccURL)
+}
+
+// migrateJobNames adds the instance to the jobs stored by old versions as a list of names.
+// Jobs that are found on no instance keep an empty instance.
+func migrateJobNames(s Storage, env *MigrationEnv) error {
+	value, ok := get(s, KeyJobs)
+	if !ok {
+		return nil
+	}
+	var names []string
+	if json.Unmarshal([]byte(value), &names) != nil {
+		// already jobs with their instance
+		return nil
+	}
+	instances := make(map[string]string)
+	if len(names) > 0 {
+		if env.ResolveInstances == nil {
+			return ErrDeferred
+		}
+		var err error
+		if instances, err = env.ResolveInstances(names); err != nil {
+			return err
+		}
+	}
+	jobs := make([]Job, len(names))
+	for i, name := range names {
+		jobs[i] = Job{Name: name, Instance: instances[name]}
+	}
+	return PutJobs(s, jobs)
+}
+
+// migrateURLCredentials moves user and password of the URLs to the credential store and
+// refers to them from the instances.
+func migrateURLCredentials(s Storage, env *MigrationEnv) error {
+	var instances []Instance
+	if value, ok := get(s, KeyInstances); ok {
+		if err := json.Unmarshal([]byte(value), &instances); err != nil {
+			return &FieldError{Key: KeyInstances, Value: value, Err: err}
+		}
+	}
+	replaced := make(map[string]string)
+	for i := 0; ; i++ {
+		key := KeyURLPrefix + strconv.Itoa(i)
+		value, ok := s.Get(key)
+		if !ok {
+			break
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.User == nil {
+			continue
+		}
+		password, ok := u.User.Password()
+		if !ok {
+			continue
+		}
+		id, err := env.StoreCredential(u.User.Username(), password)
+		if err != nil {
+			return err
+		}
+		u.User = nil
+		if err := s.Put(key, u.String()); err != nil {
+			return err
+		}
+		replaced[value] = u.String()
+		found := false
+		for j := range instances {
+			if instances[j].URL == value {
+				instances[j].URL = u.String()
+				instances[j].Credential = id
+				found = true
+			}
+		}
+		if !found {
+			instances = append(instances, Instance{URL: u.String(), Credential: id})
+		}
+	}
+	if len(replaced) == 0 {
+		return nil
+	}
+	if err := putJSONSetting(s, KeyInstances, instances); err != nil {
+		return err
+	}
+	value, ok := get(s, KeyJobs)
+	if !ok {
+		return nil
+	}
+	var jobs []Job
+	if err := json.Unmarshal([]byte(value), &jobs); err != nil {
+		return &FieldError{Key: KeyJobs, Value: value, Err: err}
+	}
+	for i, j := range jobs {
+		if u, ok := replaced[j.Instance]; ok {
+			jobs[i].Instance = u
+		}
+	}
+	return PutJobs(s, jobs)
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// KeyJobRules holds the JobRules.
+const KeyJobRules = "JobRules"
+
+// JobRule selects jobs dynamically. All conditions that are set must match.
+type JobRule struct {
+	// Instance is the name or URL of an instance. Empty matches all instances.
+	Instance string `json:",omitempty"`
+	// Pattern is a case insensitive regular expression matched against the job name and the
+	// path of the job URL. Empty matches all jobs.
+	Pattern string `json:",omitempty"`
+	// View is the URL of a Jenkins view that is polled in addition to the instances. The
+	// rule matches the jobs of the view.
+	View string `json:",omitempty"`
+}
+
+// JobRules are evaluated on every poll. Jobs matching an include rule are monitored in
+// addition to the explicit jobs unless they match an exclude rule.
+type JobRules struct {
+	Include []JobRule `json:",omitempty"`
+	Exclude []JobRule `json:",omitempty"`
+}
+
+type compiledRule struct {
+	instance string
+	pattern  *regexp.Regexp
+}
+
+// RuleSet are compiled JobRules.
+type RuleSet struct {
+	include []compiledRule
+	exclude []compiledRule
+	views   []string
+}
+
+// Empty reports whether there are no include rules.
+func (r *RuleSet) Empty() bool {
+	return r == nil || len(r.include) == 0
+}
+
+// Views returns the URLs of the views the rules need to be polled.
+func (r *RuleSet) Views() []string {
+	if r == nil {
+		return nil
+	}
+	return r.views
+}
+
+// Match reports whether the job with the given instance URL, name and URL is selected.
+func (r *RuleSet) Match(instance, name, jobURL string) bool {
+	if r == nil {
+		return false
+	}
+	var path string
+	if u, err := url.Parse(jobURL); err == nil {
+		path = u.Path
+	}
+	return matchAny(r.include, instance, name, path) && !matchAny(r.exclude, instance, name, path)
+}
+
+func matchAny(rules []compiledRule, instance, name, path string) bool {
+	for _, rule := range rules {
+		if rule.instance != "" && rule.instance != instance {
+			continue
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(name) && !rule.pattern.MatchString(path) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Rules returns the compiled job rules of the configuration.
+func (c *Config) Rules() (*RuleSet, error) {
+	if c.rules != nil {
+		return c.rules, nil
+	}
+	r := new(RuleSet)
+	var err error
+	if r.include, err = c.compileRules(c.JobRules.Include, "include"); err != nil {
+		return nil, err
+	}
+	for _, rule := range c.JobRules.Include {
+		if rule.View != "" && !contains(r.views, rule.View) {
+			if _, ok := c.Instance(rule.View); !ok {
+				r.views = append(r.views, rule.View)
+			}
+		}
+	}
+	if r.exclude, err = c.compileRules(c.JobRules.Exclude, "exclude"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Config) compileRules(rules []JobRule, kind string) ([]compiledRule, error) {
+	compiled := make([]compiledRule, len(rules))
+	for i, rule := range rules {
+		switch {
+		case rule.View != "" && rule.Instance != "":
+			return nil, fmt.Errorf("%s rule %d: instance and view must not both be set", kind, i+1)
+		case rule.View != "":
+			if err := validateURL(rule.View); err != nil {
+				return nil, fmt.Errorf("%s rule %d: view %q: %w", kind, i+1, rule.View, err)
+			}
+			compiled[i].instance = rule.View
+		case rule.Instance != "":
+			instance, ok := c.Instance(rule.Instance)
+			if !ok {
+				return nil, fmt.Errorf("%s rule %d: unknown instance %q", kind, i+1, rule.Instance)
+			}
+			compiled[i].instance = instance.URL
+		}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile("(?i)" + rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s rule %d: %w", kind, i+1, err)
+			}
+			compiled[i].pattern = pattern
+		}
+		if compiled[i].instance == "" && compiled[i].pattern == nil {
+			return nil, fmt.Errorf("%s rule %d would match every job", kind, i+1)
+		}
+	}
+	return compiled, nil
+}
+
+// PollURLs returns the URLs of the instances and of the views needed by the job rules.
+func (c *Config) PollURLs() []string {
+	urls := c.URLs()
+	rules, err := c.Rules()
+	if err != nil {
+		return urls
+	}
+	return append(urls, rules.Views()...)
+}
+
+func loadJobRules(s Storage, c *Config) *FieldError {
+	value, ok := get(s, KeyJobRules)
+	if !ok {
+		return nil
+	}
+	var rules JobRules
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return &FieldError{Key: KeyJobRules, Value: value, Err: err}
+	}
+	c.JobRules = rules
+	compiled, err := c.Rules()
+	if err != nil {
+		c.JobRules = JobRules{}
+		return &FieldError{Key: KeyJobRules, Value: value, Err: err}
+	}
+	c.rules = compiled
+	return nil
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
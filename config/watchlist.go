@@ -0,0 +1,328 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// WatchListVersion is the version of the watch list documents written by this version.
+const WatchListVersion = 1
+
+// KeySubscriptionState holds the SubscriptionState.
+const KeySubscriptionState = "SubscriptionState"
+
+// DefaultSubscriptionInterval is the time between two reads of a subscribed watch list in
+// minutes.
+const DefaultSubscriptionInterval = 60
+
+// WatchList is a document with the monitored jobs that can be shared within a team. Its
+// instances only have a URL and a name: credentials and HTTP settings stay local.
+type WatchList struct {
+	Version   int        `json:"version"`
+	Instances []Instance `json:"instances,omitempty"`
+	// Jobs refer to their instance by URL or name.
+	Jobs   []Job   `json:"jobs"`
+	Groups []Group `json:"groups,omitempty"`
+}
+
+// Subscription is a watch list read periodically from a file or URL.
+type Subscription struct {
+	// Source is the path or http(s) URL of the watch list. Empty means no subscription.
+	Source string
+	// Interval between two reads in minutes.
+	Interval int `json:",omitempty"`
+}
+
+// WatchList returns the monitored jobs with their instances and groups.
+func (c *Config) WatchList() *WatchList {
+	return &WatchList{
+		Version:   WatchListVersion,
+		Instances: sharedInstances(c.Instances),
+		Jobs:      append([]Job{}, c.Jobs...),
+		Groups:    append([]Group(nil), c.Groups...),
+	}
+}
+
+// ParseWatchList decodes a watch list and resolves the instance names of its jobs to URLs.
+func ParseWatchList(data []byte) (*WatchList, error) {
+	w := new(WatchList)
+	if err := json.Unmarshal(data, w); err != nil {
+		return nil, err
+	}
+	switch {
+	case w.Version == 0:
+		return nil, errors.New("not a watch list, the version is missing")
+	case w.Version > WatchListVersion:
+		return nil, fmt.Errorf("watch list version %d is not supported by this version", w.Version)
+	}
+	for i, instance := range w.Instances {
+		if err := validateURL(instance.URL); err != nil {
+			return nil, fmt.Errorf("instance %d %q: %w", i+1, instance.URL, err)
+		}
+	}
+	// a shared document must not pick a stored credential or the HTTP settings
+	w.Instances = sharedInstances(w.Instances)
+	for i, j := range w.Jobs {
+		if j.Name == "" {
+			return nil, fmt.Errorf("job %d has no name", i+1)
+		}
+		for _, instance := range w.Instances {
+			if instance.Name != "" && instance.Name == j.Instance {
+				w.Jobs[i].Instance = instance.URL
+				break
+			}
+		}
+	}
+	return w, nil
+}
+
+// ReadWatchList reads a watch list from a file or an http(s) URL.
+func ReadWatchList(source string, client *http.Client) (*WatchList, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = download(client, source)
+	} else {
+		data, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w, err := ParseWatchList(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return w, nil
+}
+
+func download(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// WriteFile writes the watch list as indented JSON.
+func (w *WatchList) WriteFile(path string) error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Merge adds the instances, jobs and groups of other that are missing in w.
+func (w *WatchList) Merge(other *WatchList) {
+	w.Instances = mergeInstances(w.Instances, other.Instances)
+	w.Jobs = mergeJobs(w.Jobs, other.Jobs)
+	w.Groups = mergeGroups(w.Groups, other.Groups)
+}
+
+// PutWatchList replaces the stored instances, jobs and groups. Instances already stored
+// keep their settings, new ones get only their URL and name.
+func PutWatchList(s Storage, w *WatchList) error {
+	var stored []Instance
+	if value, ok := get(s, KeyInstances); ok {
+		// invalid settings are replaced like by Load
+		json.Unmarshal([]byte(value), &stored)
+	}
+	urls := make([]string, len(w.Instances))
+	instances := make([]Instance, len(w.Instances))
+	for i, instance := range w.Instances {
+		urls[i] = instance.URL
+		instances[i] = instance.shared()
+		for _, local := range stored {
+			if local.URL == instance.URL {
+				instances[i] = local
+				break
+			}
+		}
+	}
+	if err := PutURLs(s, urls); err != nil {
+		return err
+	}
+	if err := PutInstances(s, instances); err != nil {
+		return err
+	}
+	if err := PutJobs(s, w.Jobs); err != nil {
+		return err
+	}
+	return putJSONSetting(s, KeyGroups, w.Groups)
+}
+
+func putJSONSetting(s Storage, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(key, string(data))
+}
+
+// SubscriptionState remembers the last read watch list and the personal changes to it.
+type SubscriptionState struct {
+	List    *WatchList `json:",omitempty"`
+	Added   []Job      `json:",omitempty"`
+	Removed []Job      `json:",omitempty"`
+}
+
+// LoadSubscriptionState reads the state of the subscription. Without one it is empty.
+func LoadSubscriptionState(s Storage) (*SubscriptionState, error) {
+	state := new(SubscriptionState)
+	value, ok := get(s, KeySubscriptionState)
+	if !ok {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return new(SubscriptionState), &FieldError{Key: KeySubscriptionState, Value: value, Err: err}
+	}
+	return state, nil
+}
+
+// Put stores the state.
+func (st *SubscriptionState) Put(s Storage) error {
+	return putJSONSetting(s, KeySubscriptionState, st)
+}
+
+// Track records how the personal jobs differ from the subscribed ones.
+func (st *SubscriptionState) Track(jobs []Job) {
+	if st.List == nil {
+		return
+	}
+	st.Added = subtractJobs(jobs, st.List.Jobs)
+	st.Removed = subtractJobs(st.List.Jobs, jobs)
+}
+
+// Apply replaces the subscribed watch list by list and returns the merged watch list:
+// the subscribed jobs without the personally removed ones plus the personally added ones.
+// Instances and groups in current that did not come from the previous list are kept.
+// PutWatchList keeps the local settings of the instances the list shares with current.
+func (st *SubscriptionState) Apply(current, list *WatchList) *WatchList {
+	var previous WatchList
+	if st.List != nil {
+		previous = *st.List
+	} else {
+		// the jobs monitored before subscribing stay as personal additions
+		st.Added = append([]Job(nil), current.Jobs...)
+	}
+	merged := &WatchList{
+		Version:   WatchListVersion,
+		Instances: mergeInstances(list.Instances, subtractInstances(current.Instances, previous.Instances)),
+		Jobs:      mergeJobs(subtractJobs(list.Jobs, st.Removed), st.Added),
+		Groups:    mergeGroups(list.Groups, subtractGroups(current.Groups, previous.Groups)),
+	}
+	st.List = list
+	return merged
+}
+
+func sameJob(a, b Job) bool {
+	return a.Name == b.Name && a.Instance == b.Instance
+}
+
+func containsJob(jobs []Job, j Job) bool {
+	for _, other := range jobs {
+		if sameJob(other, j) {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeJobs(a, b []Job) []Job {
+	merged := append([]Job{}, a...)
+	for _, j := range b {
+		if !containsJob(merged, j) {
+			merged = append(merged, j)
+		}
+	}
+	return merged
+}
+
+func subtractJobs(a, b []Job) []Job {
+	var rest []Job
+	for _, j := range a {
+		if !containsJob(b, j) {
+			rest = append(rest, j)
+		}
+	}
+	return rest
+}
+
+// shared returns the part of the instance that may be shared with others.
+func (instance Instance) shared() Instance {
+	return Instance{URL: instance.URL, Name: instance.Name}
+}
+
+func sharedInstances(instances []Instance) []Instance {
+	var shared []Instance
+	for _, instance := range instances {
+		shared = append(shared, instance.shared())
+	}
+	return shared
+}
+
+func containsInstance(instances []Instance, url string) bool {
+	for _, instance := range instances {
+		if instance.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeInstances(a, b []Instance) []Instance {
+	merged := append([]Instance(nil), a...)
+	for _, instance := range b {
+		if !containsInstance(merged, instance.URL) {
+			merged = append(merged, instance)
+		}
+	}
+	return merged
+}
+
+func subtractInstances(a, b []Instance) []Instance {
+	var rest []Instance
+	for _, instance := range a {
+		if !containsInstance(b, instance.URL) {
+			rest = append(rest, instance)
+		}
+	}
+	return rest
+}
+
+func containsGroup(groups []Group, name string) bool {
+	for _, g := range groups {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeGroups(a, b []Group) []Group {
+	merged := append([]Group(nil), a...)
+	for _, g := range b {
+		if !containsGroup(merged, g.Name) {
+			merged = append(merged, g)
+		}
+	}
+	return merged
+}
+
+func subtractGroups(a, b []Group) []Group {
+	var rest []Group
+	for _, g := range a {
+		if !containsGroup(b, g.Name) {
+			rest = append(rest, g)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWatchListSharesNoSecrets(t *testing.T) {
+	c, err := Load(newMemStorage(map[string]string{
+		KeyURLPrefix + "0": "http://jenkins-a/",
+		KeyInstances:       `[{"URL":"http://jenkins-a/","Name":"a","Credential":"c1","ClientCert":"a.pem","ClientKey":"a.key","Proxy":"none"}]`,
+		KeyJobs:            `[{"Name":"build","Instance":"http://jenkins-a/"}]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Instance{{URL: "http://jenkins-a/", Name: "a"}}
+	if w := c.WatchList(); !reflect.DeepEqual(w.Instances, want) {
+		t.Errorf("WatchList().Instances = %+v, want %+v", w.Instances, want)
+	}
+}
+
+func TestParseWatchListDropsLocalSettings(t *testing.T) {
+	w, err := ParseWatchList([]byte(`{"version":1,
+		"instances":[{"URL":"http://jenkins-a/","Name":"a","Credential":"c1","Insecure":true,"Proxy":"http://evil/","CABundle":"ca.pem"}],
+		"jobs":[{"Name":"build","Instance":"a"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &WatchList{
+		Version:   1,
+		Instances: []Instance{{URL: "http://jenkins-a/", Name: "a"}},
+		Jobs:      []Job{{Name: "build", Instance: "http://jenkins-a/"}},
+	}
+	if !reflect.DeepEqual(w, want) {
+		t.Errorf("ParseWatchList = %+v, want %+v", w, want)
+	}
+}
+
+// TestSubscriptionKeepsLocalSettings applies a subscribed list to an instance that is
+// configured locally.
+func TestSubscriptionKeepsLocalSettings(t *testing.T) {
+	s := newMemStorage(map[string]string{
+		KeyURLPrefix + "0": "http://jenkins-a/",
+		KeyInstances:       `[{"URL":"http://jenkins-a/","Name":"mine","Credential":"c1","CABundle":"ca.pem"}]`,
+		KeyJobs:            `[{"Name":"build","Instance":"http://jenkins-a/"}]`,
+	})
+	c, err := Load(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := ParseWatchList([]byte(`{"version":1,
+		"instances":[{"URL":"http://jenkins-a/","Credential":"c2","Insecure":true},{"URL":"http://jenkins-b/","Credential":"c1","Proxy":"http://evil/"}],
+		"jobs":[{"Name":"deploy","Instance":"http://jenkins-b/"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := new(SubscriptionState)
+	if err := PutWatchList(s, state.Apply(c.WatchList(), list)); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Load(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInstances := []Instance{
+		{URL: "http://jenkins-a/", Name: "mine", Credential: "c1", CABundle: "ca.pem"},
+		{URL: "http://jenkins-b/"},
+	}
+	if !reflect.DeepEqual(c.Instances, wantInstances) {
+		t.Errorf("Instances = %+v, want %+v", c.Instances, wantInstances)
+	}
+	wantJobs := []Job{{Name: "deploy", Instance: "http://jenkins-b/"}, {Name: "build", Instance: "http://jenkins-a/"}}
+	if !reflect.DeepEqual(c.Jobs, wantJobs) {
+		t.Errorf("Jobs = %+v, want %+v", c.Jobs, wantJobs)
+	}
+}
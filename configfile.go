@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"JenkinsCheck/config"
+
+	"github.com/lxn/walk"
+)
+
+// fileSettings reads the keys defined by the optional config file from the file and all
+// other keys from settings.ini. Changes are always written to settings.ini.
+type fileSettings struct {
+	*walk.IniFileSettings
+	file *config.Overlay
+	path string
+}
+
+func newFileSettings(ini *walk.IniFileSettings) *fileSettings {
+	s := &fileSettings{
+		IniFileSettings: ini,
+		file:            new(config.Overlay),
+		path:            filepath.Join(filepath.Dir(ini.FilePath()), config.FileName),
+	}
+	values, err := config.ReadValues(s.path)
+	if err != nil {
+		log.Println("config file:", err)
+	}
+	s.file.Set(values)
+	return s
+}
+
+func (s *fileSettings) Get(key string) (string, bool) {
+	if value, ok := s.file.Get(key); ok {
+		return value, true
+	}
+	if s.file.Hides(key) {
+		return "", false
+	}
+	return s.IniFileSettings.Get(key)
+}
+
+// watch applies changes of the config file until stop is closed. An invalid file keeps
+// the previous values.
+func (s *fileSettings) watch(mw *jenkinsMainWindow, stop <-chan struct{}) {
+	defer handlePanic()
+	config.Watch(s.path, 2*time.Second, stop, func(values map[string]string, err error) {
+		if err != nil {
+			log.Println("config file: keeping the previous settings:", err)
+			return
+		}
+		log.Println("config file changed, reloading settings")
+		mw.Synchronize(func() {
+			defer handlePanic()
+			s.file.Set(values)
+			mw.reInit()
+		})
+	})
+}
+
+// exportConfigFile writes the settings of settings.ini as config file.
+func (mw *jenkinsMainWindow) exportConfigFile() {
+	defer handlePanic()
+	settings, ok := walk.App().Settings().(*fileSettings)
+	if !ok {
+		return
+	}
+	f, err := config.Export(settings.IniFileSettings)
+	if err != nil {
+		walk.MsgBox(mw, "Export config file", "The settings are invalid:\n"+err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	fileDlg := &walk.FileDialog{
+		Title:          "Export config file",
+		Filter:         "YAML files (*.yaml)|*.yaml",
+		FilePath:       settings.path,
+		InitialDirPath: filepath.Dir(settings.path),
+	}
+	ok, err = fileDlg.ShowSave(mw)
+	if err != nil {
+		log.Println(err)
+	}
+	if !ok {
+		return
+	}
+	if err := f.WriteFile(fileDlg.FilePath); err != nil {
+		walk.MsgBox(mw, "Export config file", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	log.Println("exported settings to", fileDlg.FilePath)
+}
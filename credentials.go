@@ -0,0 +1,505 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"JenkinsCheck/config"
+	"JenkinsCheck/credentials"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+// keyCredentialProtection selects how the credential file is encrypted: "os" (default) or
+// "passphrase".
+const keyCredentialProtection = "CredentialProtection"
+
+var (
+	// credentialStore is nil if the credential file could not be opened.
+	credentialStore *credentials.FileStore
+	// redactor removes the tokens from the log.
+	redactor = new(credentials.Redactor)
+)
+
+// openCredentialStore opens the credential file next to the settings. With passphrase
+// protection, or if the operating system cannot protect the file, the passphrase is asked.
+func openCredentialStore(settings *fileSettings) {
+	path := filepath.Join(filepath.Dir(settings.FilePath()), credentials.FileName)
+	protection, _ := settings.Get(keyCredentialProtection)
+	if protection != "passphrase" {
+		protector, err := credentials.OSProtector()
+		if err == nil {
+			credentialStore = credentials.NewFileStore(path, protector)
+			if err = updateRedactor(); err == nil {
+				return
+			}
+		}
+		log.Println("credential store:", err)
+	}
+	for tries := 0; tries < 3; tries++ {
+		passphrase, ok := askPassphrase(tries > 0)
+		if !ok {
+			break
+		}
+		credentialStore = credentials.NewFileStore(path, credentials.Passphrase(passphrase))
+		err := updateRedactor()
+		if err == nil {
+			return
+		}
+		log.Println("credential store:", err)
+		if !errors.Is(err, credentials.ErrWrongPassphrase) {
+			break
+		}
+	}
+	credentialStore = nil
+	log.Println("credential store not available, instances are accessed without credentials")
+}
+
+// updateRedactor makes the redactor remove all stored tokens.
+func updateRedactor() error {
+	list, err := credentialStore.List()
+	if err != nil {
+		return err
+	}
+	secrets := make([]string, len(list))
+	for i, c := range list {
+		secrets[i] = c.Token
+	}
+	redactor.SetSecrets(secrets)
+	return nil
+}
+
+// storeCredential saves a new credential for the settings migration.
+func storeCredential(user, token string) (string, error) {
+	if credentialStore == nil {
+		return "", errors.New("credential store not available")
+	}
+	c := credentials.Credential{ID: credentials.NewID(), User: user, Token: token}
+	if err := credentialStore.Put(c); err != nil {
+		return "", err
+	}
+	return c.ID, updateRedactor()
+}
+
+// notifierLogin returns the user name and password of a notifier: the ones of the stored
+// credential with the given id if it is set, otherwise the ones of the settings. Passwords are
+// kept out of the log either way.
+func notifierLogin(owner, id, user, password string) (string, string) {
+	if id != "" {
+		var c credentials.Credential
+		err := errors.New("credential store not available")
+		if credentialStore != nil {
+			c, err = credentialStore.Get(id)
+		}
+		if err != nil {
+			log.Println(owner+": credential", id+":", err)
+		} else {
+			if c.User != "" {
+				user = c.User
+			}
+			password = c.Token
+		}
+	}
+	redactor.AddSecret(password)
+	return user, password
+}
+
+func askPassphrase(retry bool) (string, bool) {
+	var dlg *walk.Dialog
+	var passphraseBox *walk.LineEdit
+	var okPB, cancelPB *walk.PushButton
+	text := "Passphrase of the credential store:"
+	if retry {
+		text = "Wrong passphrase, please try again:"
+	}
+	err := Dialog{
+		AssignTo:      &dlg,
+		Title:         walk.App().ProductName() + " credentials",
+		DefaultButton: &okPB,
+		CancelButton:  &cancelPB,
+		MinSize:       Size{Width: 350},
+		Layout:        VBox{},
+		Children: []Widget{
+			Label{Text: text},
+			LineEdit{
+				AssignTo:     &passphraseBox,
+				PasswordMode: true,
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &okPB,
+						Text:     "Ok",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdOK)
+						},
+					},
+					PushButton{
+						AssignTo: &cancelPB,
+						Text:     "Cancel",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdCancel)
+						},
+					},
+				},
+			},
+		},
+	}.Create(nil)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	if dlg.Run() != walk.DlgCmdOK || passphraseBox.Text() == "" {
+		return "", false
+	}
+	return passphraseBox.Text(), true
+}
+
+// credentialFor returns the credential for a request to u. Instances with a stored credential
+// use it, other instances ask the credential helper and the .netrc file. The source is set
+// for credentials that may be refreshed. Hosts that are no instance get no credential.
+func credentialFor(u *url.URL) (credentials.Credential, credentials.Source, bool) {
+	best, ok := instanceFor(u)
+	if !ok {
+		return credentials.Credential{}, nil, false
+	}
+	if best.Credential != "" {
+		if credentialStore == nil {
+			return credentials.Credential{}, nil, false
+		}
+		c, err := credentialStore.Get(best.Credential)
+		if err != nil {
+			log.Println(best.URL, "credential:", err)
+			return credentials.Credential{}, nil, false
+		}
+		return c, nil, true
+	}
+	source := credentialSources()
+	c, ok := hostCredentials.lookup(source, u.Scheme, u.Host)
+	return c, source, ok
+}
+
+// credentialSources returns the credential helper and the .netrc file.
+func credentialSources() credentials.Sources {
+	cfg := getConfig()
+	var sources credentials.Sources
+	if cfg.CredentialHelper != "" {
+		sources = append(sources, &credentials.Helper{
+			Command:     cfg.CredentialHelper,
+			SysProcAttr: &syscall.SysProcAttr{HideWindow: true},
+		})
+	}
+	return append(sources, &credentials.Netrc{Path: cfg.Netrc})
+}
+
+// cachedCredential is a result of the sources. Without ok the sources had no credential.
+type cachedCredential struct {
+	credential credentials.Credential
+	ok         bool
+}
+
+// credentialCache remembers the results of the sources by protocol and host, so the helper
+// is not run for every request. The sources run outside the lock, concurrent lookups of the
+// same host wait for the first one.
+type credentialCache struct {
+	mutex       sync.Mutex
+	credentials map[string]cachedCredential
+	pending     map[string]*pendingLookup
+	// generation is increased by clear, so results of lookups started before are not kept.
+	generation int
+}
+
+// pendingLookup is a lookup running the sources. done is closed when the result is set.
+type pendingLookup struct {
+	done   chan struct{}
+	result cachedCredential
+}
+
+var hostCredentials = &credentialCache{
+	credentials: make(map[string]cachedCredential),
+	pending:     make(map[string]*pendingLookup),
+}
+
+// clear forgets all results, e.g. after the sources changed.
+func (cache *credentialCache) clear() {
+	cache.mutex.Lock()
+	cache.credentials = make(map[string]cachedCredential)
+	cache.pending = make(map[string]*pendingLookup)
+	cache.generation++
+	cache.mutex.Unlock()
+}
+
+func (cache *credentialCache) lookup(source credentials.Source, protocol, host string) (credentials.Credential, bool) {
+	key := protocol + "://" + host
+	cache.mutex.Lock()
+	if cached, ok := cache.credentials[key]; ok {
+		cache.mutex.Unlock()
+		return cached.credential, cached.ok
+	}
+	if p, ok := cache.pending[key]; ok {
+		cache.mutex.Unlock()
+		<-p.done
+		return p.result.credential, p.result.ok
+	}
+	p := &pendingLookup{done: make(chan struct{})}
+	cache.pending[key] = p
+	generation := cache.generation
+	cache.mutex.Unlock()
+
+	c, ok, err := source.Lookup(protocol, host)
+	if err != nil {
+		// try again with the next request
+		log.Println(key, "credential:", err)
+	} else {
+		redactor.AddSecret(c.Token)
+		p.result = cachedCredential{credential: c, ok: ok}
+	}
+
+	cache.mutex.Lock()
+	if cache.generation == generation {
+		delete(cache.pending, key)
+		if err == nil {
+			cache.credentials[key] = p.result
+		}
+	}
+	cache.mutex.Unlock()
+	close(p.done)
+	return p.result.credential, p.result.ok
+}
+
+// reject drops a refused credential and tells its source.
+func (cache *credentialCache) reject(source credentials.Source, protocol, host string, c credentials.Credential) {
+	key := protocol + "://" + host
+	cache.mutex.Lock()
+	if cached, ok := cache.credentials[key]; ok && cached.credential == c {
+		delete(cache.credentials, key)
+	}
+	cache.mutex.Unlock()
+	if err := source.Reject(protocol, host, c); err != nil {
+		log.Println(key, "credential:", err)
+	}
+}
+
+// authTransport adds the credential of the instance to the requests going to it. If a
+// credential from the helper or .netrc is refused, it is refreshed and the request repeated
+// once.
+type authTransport struct {
+	base http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	c, source, ok := credentialFor(req.URL)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	resp, err := t.base.RoundTrip(withBasicAuth(req, c))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || source == nil {
+		return resp, err
+	}
+	log.Println(req.URL.Host, "refused the credential, refreshing it")
+	hostCredentials.reject(source, req.URL.Scheme, req.URL.Host, c)
+	refreshed, _, ok := credentialFor(req.URL)
+	if !ok || refreshed == c {
+		return resp, nil
+	}
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	resp.Body.Close()
+	return t.base.RoundTrip(withBasicAuth(req, refreshed))
+}
+
+func withBasicAuth(req *http.Request, c credentials.Credential) *http.Request {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(c.User, c.Token)
+	return req
+}
+
+// openCredentialsDialog adds, changes and deletes credentials and assigns them to instances.
+func (mw *jenkinsMainWindow) openCredentialsDialog() {
+	defer handlePanic()
+	if credentialStore == nil {
+		walk.MsgBox(mw, "Credentials", "The credential store is not available, see the log.", walk.MsgBoxIconError)
+		return
+	}
+	list, err := credentialStore.List()
+	if err != nil {
+		walk.MsgBox(mw, "Credentials", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	instances := append([]config.Instance(nil), getConfig().Instances...)
+	instanceURLs := append([]string{""}, getConfig().URLs()...)
+	ids := func() []string {
+		ids := make([]string, len(list))
+		for i, c := range list {
+			ids[i] = c.ID
+		}
+		return ids
+	}
+	instanceOf := func(id string) int {
+		for i, instance := range instances {
+			if instance.Credential == id {
+				return i + 1
+			}
+		}
+		return 0
+	}
+
+	var dlg *walk.Dialog
+	var idLb *walk.ListBox
+	var idBox, userBox, tokenBox *walk.LineEdit
+	var instanceCB *walk.ComboBox
+	var closePB *walk.PushButton
+	save := func() {
+		id := strings.TrimSpace(idBox.Text())
+		if id == "" {
+			id = credentials.NewID()
+		}
+		c := credentials.Credential{ID: id, User: userBox.Text(), Token: tokenBox.Text()}
+		if err := credentialStore.Put(c); err != nil {
+			walk.MsgBox(dlg, "Credentials", err.Error(), walk.MsgBoxIconError)
+			return
+		}
+		for i := range instances {
+			if instances[i].Credential == id {
+				instances[i].Credential = ""
+			}
+		}
+		if idx := instanceCB.CurrentIndex(); idx > 0 {
+			instances[idx-1].Credential = id
+		}
+		if err := saveInstances(instances); err != nil {
+			walk.MsgBox(dlg, "Credentials", err.Error(), walk.MsgBoxIconError)
+		}
+		list, _ = credentialStore.List()
+		idLb.SetModel(ids())
+		if err := updateRedactor(); err != nil {
+			log.Println(err)
+		}
+	}
+	err = Dialog{
+		AssignTo:     &dlg,
+		Title:        "Credentials",
+		Icon:         mw.Icon(),
+		CancelButton: &closePB,
+		MinSize:      Size{Width: 600, Height: 300},
+		Layout:       HBox{},
+		Children: []Widget{
+			ListBox{
+				AssignTo: &idLb,
+				Model:    ids(),
+				OnCurrentIndexChanged: func() {
+					idx := idLb.CurrentIndex()
+					if idx < 0 || idx >= len(list) {
+						return
+					}
+					c := list[idx]
+					idBox.SetText(c.ID)
+					userBox.SetText(c.User)
+					tokenBox.SetText(c.Token)
+					instanceCB.SetCurrentIndex(instanceOf(c.ID))
+				},
+			},
+			Composite{
+				Layout: Grid{Columns: 2},
+				Children: []Widget{
+					Label{Text: "Id:"},
+					LineEdit{AssignTo: &idBox},
+					Label{Text: "User:"},
+					LineEdit{AssignTo: &userBox},
+					Label{Text: "API token:"},
+					LineEdit{
+						AssignTo:     &tokenBox,
+						PasswordMode: true,
+					},
+					Label{Text: "Instance:"},
+					ComboBox{
+						AssignTo: &instanceCB,
+						Model:    instanceURLs,
+					},
+					Composite{
+						ColumnSpan: 2,
+						Layout:     HBox{},
+						Children: []Widget{
+							HSpacer{},
+							PushButton{
+								Text:      "Save",
+								OnClicked: save,
+							},
+							PushButton{
+								Text: "Delete",
+								OnClicked: func() {
+									id := idBox.Text()
+									if err := credentialStore.Delete(id); err != nil {
+										walk.MsgBox(dlg, "Credentials", err.Error(), walk.MsgBoxIconError)
+										return
+									}
+									for i := range instances {
+										if instances[i].Credential == id {
+											instances[i].Credential = ""
+										}
+									}
+									if err := saveInstances(instances); err != nil {
+										walk.MsgBox(dlg, "Credentials", err.Error(), walk.MsgBoxIconError)
+									}
+									list, _ = credentialStore.List()
+									idLb.SetModel(ids())
+									idBox.SetText("")
+									userBox.SetText("")
+									tokenBox.SetText("")
+									instanceCB.SetCurrentIndex(0)
+								},
+							},
+							PushButton{
+								AssignTo: &closePB,
+								Text:     "Close",
+								OnClicked: func() {
+									dlg.Close(walk.DlgCmdCancel)
+								},
+							},
+						},
+					},
+					VSpacer{ColumnSpan: 2},
+				},
+			},
+		},
+	}.Create(mw)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	dlg.Run()
+}
+
+// saveInstances stores the instances and applies them.
+func saveInstances(instances []config.Instance) error {
+	settings := walk.App().Settings()
+	if err := config.PutInstances(settings, instances); err != nil {
+		return err
+	}
+	if err := settings.Save(); err != nil {
+		return err
+	}
+	loadConfig()
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package credentials keeps the API tokens of the Jenkins instances in an encrypted file. The
+// settings only refer to a credential by its id.
+package credentials
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileName is the name of the credential file next to the settings.
+const FileName = "credentials.dat"
+
+// fileVersion is the version of the credential file format.
+const fileVersion = 1
+
+// ErrNotFound is returned for unknown credential ids.
+var ErrNotFound = errors.New("credential not found")
+
+// Credential is a user and the API token or password used for an instance.
+type Credential struct {
+	ID    string
+	User  string
+	Token string
+}
+
+// Store holds credentials by id.
+type Store interface {
+	Get(id string) (Credential, error)
+	Put(c Credential) error
+	Delete(id string) error
+	// List returns all credentials sorted by id.
+	List() ([]Credential, error)
+}
+
+// file is the format of the credential file. Data are the protected credentials.
+type file struct {
+	Version   int    `json:"version"`
+	Protector string `json:"protector"`
+	Data      []byte `json:"data"`
+}
+
+// FileStore keeps the credentials in a file encrypted by a Protector. The file is read on
+// first use and written on every change.
+type FileStore struct {
+	path        string
+	protector   Protector
+	mutex       sync.Mutex
+	credentials map[string]Credential
+}
+
+// NewFileStore returns a store for the file at path.
+func NewFileStore(path string, p Protector) *FileStore {
+	return &FileStore{path: path, protector: p}
+}
+
+// Path returns the path of the credential file.
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+func (s *FileStore) Get(id string) (Credential, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.load(); err != nil {
+		return Credential{}, err
+	}
+	c, ok := s.credentials[id]
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	return c, nil
+}
+
+func (s *FileStore) Put(c Credential) error {
+	if strings.TrimSpace(c.ID) == "" {
+		return errors.New("the credential has no id")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.credentials[c.ID] = c
+	return s.save()
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if _, ok := s.credentials[id]; !ok {
+		return nil
+	}
+	delete(s.credentials, id)
+	return s.save()
+}
+
+func (s *FileStore) List() ([]Credential, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	list := make([]Credential, 0, len(s.credentials))
+	for _, c := range s.credentials {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// load reads the file unless it was read before. A missing file is an empty store. The
+// caller must hold the mutex.
+func (s *FileStore) load() error {
+	if s.credentials != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		s.credentials = make(map[string]Credential)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	switch {
+	case f.Version > fileVersion:
+		return fmt.Errorf("%s: version %d is not supported by this version", s.path, f.Version)
+	case f.Protector != s.protector.Name():
+		return fmt.Errorf("%s: protected by %s, not by %s", s.path, f.Protector, s.protector.Name())
+	}
+	plain, err := s.protector.Unprotect(f.Data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	credentials := make(map[string]Credential)
+	if err := json.Unmarshal(plain, &credentials); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.credentials = credentials
+	return nil
+}
+
+// save replaces the file. The caller must hold the mutex.
+func (s *FileStore) save() error {
+	plain, err := json.Marshal(s.credentials)
+	if err != nil {
+		return err
+	}
+	protected, err := s.protector.Protect(plain)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(file{
+		Version:   fileVersion,
+		Protector: s.protector.Name(),
+		Data:      protected,
+	})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// NewID returns a random id for a new credential.
+func NewID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", b)
+}
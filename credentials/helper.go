@@ -0,0 +1,120 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultHelperTimeout limits the time a credential helper may take.
+const DefaultHelperTimeout = 30 * time.Second
+
+// Helper runs a program speaking the git credential protocol: the attributes protocol and
+// host are written to its input, username and password are read from its output.
+type Helper struct {
+	// Command is the command line of the program. The action get or erase is appended.
+	Command string
+	// SysProcAttr is set on the started processes, e.g. to hide their window.
+	SysProcAttr *syscall.SysProcAttr
+	Timeout     time.Duration
+}
+
+func (h *Helper) Lookup(protocol, host string) (Credential, bool, error) {
+	attrs, err := h.run("get", map[string]string{"protocol": protocol, "host": host})
+	if err != nil {
+		return Credential{}, false, err
+	}
+	if attrs["password"] == "" {
+		return Credential{}, false, nil
+	}
+	return Credential{User: attrs["username"], Token: attrs["password"]}, true, nil
+}
+
+// Reject makes the helper erase the credential, e.g. to fetch a new token the next time.
+func (h *Helper) Reject(protocol, host string, c Credential) error {
+	_, err := h.run("erase", map[string]string{
+		"protocol": protocol,
+		"host":     host,
+		"username": c.User,
+		"password": c.Token,
+	})
+	return err
+}
+
+func (h *Helper) run(action string, attrs map[string]string) (map[string]string, error) {
+	args := splitCommand(h.Command)
+	if len(args) == 0 {
+		return nil, errors.New("no credential helper")
+	}
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultHelperTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var input bytes.Buffer
+	for _, key := range []string{"protocol", "host", "username", "password"} {
+		if value, ok := attrs[key]; ok {
+			fmt.Fprintf(&input, "%s=%s\n", key, value)
+		}
+	}
+	input.WriteString("\n")
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], action)...)
+	cmd.SysProcAttr = h.SysProcAttr
+	cmd.Stdin = &input
+	var output, errOutput bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &errOutput
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("credential helper %s killed after %s", args[0], timeout)
+		}
+		return nil, fmt.Errorf("credential helper %s: %w: %s", args[0], err, strings.TrimSpace(errOutput.String()))
+	}
+	result := make(map[string]string)
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		if idx := strings.IndexByte(line, '='); idx > 0 {
+			result[line[:idx]] = line[idx+1:]
+		}
+	}
+	return result, nil
+}
+
+// splitCommand splits a command line at spaces outside of double quotes.
+func splitCommand(command string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range command {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
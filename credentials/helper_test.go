@@ -0,0 +1,127 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubHelper writes a credential helper script logging its action and input to log.
+func stubHelper(t *testing.T, body string) (command, log string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub helper is a shell script")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	dir := tempDir(t)
+	log = filepath.Join(dir, "helper log")
+	script := filepath.Join(dir, "git credential stub")
+	content := "#!/bin/sh\nlog=\"" + log + "\"\necho \"action=$2\" >>\"$log\"\ncat >>\"$log\"\n" + body
+	if err := ioutil.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return `sh "` + script + `" --stub`, log
+}
+
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHelperLookup(t *testing.T) {
+	command, log := stubHelper(t, `[ "$2" = get ] && printf 'protocol=https\r\nusername=jc\r\npassword=11aa=22bb\r\n\r\nignored=1\n'
+exit 0
+`)
+	h := &Helper{Command: command}
+	c, ok, err := h.Lookup("https", "jenkins-a:8443")
+	if err != nil || !ok {
+		t.Fatalf("Lookup = %+v, %v, %v", c, ok, err)
+	}
+	if want := (Credential{User: "jc", Token: "11aa=22bb"}); c != want {
+		t.Errorf("Lookup = %+v, want %+v", c, want)
+	}
+	if err := h.Reject("https", "jenkins-a:8443", c); err != nil {
+		t.Fatal(err)
+	}
+	want := "action=get\nprotocol=https\nhost=jenkins-a:8443\n\n" +
+		"action=erase\nprotocol=https\nhost=jenkins-a:8443\nusername=jc\npassword=11aa=22bb\n\n"
+	if got := readLog(t, log); got != want {
+		t.Errorf("the helper got\n%q, want\n%q", got, want)
+	}
+}
+
+func TestHelperWithoutCredential(t *testing.T) {
+	command, _ := stubHelper(t, "echo username=jc\n")
+	c, ok, err := (&Helper{Command: command}).Lookup("https", "jenkins-a")
+	if ok || err != nil {
+		t.Errorf("Lookup without password = %+v, %v, %v", c, ok, err)
+	}
+}
+
+func TestHelperFailures(t *testing.T) {
+	failing, _ := stubHelper(t, "echo 'no keyring' >&2\nexit 1\n")
+	slow, _ := stubHelper(t, "exec sleep 5\n")
+	tests := []struct {
+		name   string
+		helper *Helper
+		want   string
+	}{
+		{"exit code", &Helper{Command: failing}, "no keyring"},
+		{"timeout", &Helper{Command: slow, Timeout: 100 * time.Millisecond}, "killed after 100ms"},
+		{"no command", &Helper{Command: "  "}, "no credential helper"},
+	}
+	for _, test := range tests {
+		_, ok, err := test.helper.Lookup("https", "jenkins-a")
+		if ok || err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: Lookup = %v, %v, want an error containing %q", test.name, ok, err, test.want)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"git credential-manager", []string{"git", "credential-manager"}},
+		{`"C:\Program Files\Git\git.exe"  credential`, []string{`C:\Program Files\Git\git.exe`, "credential"}},
+		{`helper --store="a b" x`, []string{"helper", "--store=a b", "x"}},
+		{`helper ""`, []string{"helper", ""}},
+		{" ", nil},
+	}
+	for _, test := range tests {
+		if got := splitCommand(test.command); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+}
+
+// TestSourcesReject looks up a credential the server then refuses, like after a 401.
+func TestSourcesReject(t *testing.T) {
+	command, log := stubHelper(t, `[ "$2" = get ] && echo password=expired
+exit 0
+`)
+	sources := Sources{&Netrc{Path: filepath.Join(tempDir(t), "missing")}, &Helper{Command: command}}
+	c, ok, err := sources.Lookup("https", "jenkins-a")
+	if err != nil || !ok || c.Token != "expired" {
+		t.Fatalf("Lookup = %+v, %v, %v", c, ok, err)
+	}
+	if err := sources.Reject("https", "jenkins-a", c); err != nil {
+		t.Fatal(err)
+	}
+	got := readLog(t, log)
+	if !strings.HasSuffix(got, "action=erase\nprotocol=https\nhost=jenkins-a\nusername=\npassword=expired\n\n") {
+		t.Errorf("the helper got %q, want an erase of the refused credential", got)
+	}
+}
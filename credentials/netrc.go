@@ -0,0 +1,162 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Netrc reads the credentials of hosts from a .netrc file.
+type Netrc struct {
+	// Path of the file. Empty means DefaultNetrcPath.
+	Path string
+}
+
+// DefaultNetrcPath returns $NETRC or .netrc in the home directory, on Windows _netrc if
+// there is no .netrc.
+func DefaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(home, ".netrc")
+	if runtime.GOOS == "windows" {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return filepath.Join(home, "_netrc")
+		}
+	}
+	return path
+}
+
+// Lookup reads the file on every call, so changes apply without a restart. A missing file
+// has no credentials.
+func (n *Netrc) Lookup(protocol, host string) (Credential, bool, error) {
+	path := n.Path
+	if path == "" {
+		path = DefaultNetrcPath()
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || path == "" {
+		return Credential{}, false, nil
+	}
+	if err != nil {
+		return Credential{}, false, err
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var fallback *Credential
+	for _, entry := range parseNetrc(string(data)) {
+		if entry.machine == "" {
+			if fallback == nil {
+				fallback = &Credential{User: entry.login, Token: entry.password}
+			}
+			continue
+		}
+		if strings.EqualFold(entry.machine, host) {
+			return Credential{User: entry.login, Token: entry.password}, true, nil
+		}
+	}
+	if fallback != nil {
+		return *fallback, true, nil
+	}
+	return Credential{}, false, nil
+}
+
+// Reject does nothing, the file is read again on the next lookup.
+func (n *Netrc) Reject(protocol, host string, c Credential) error {
+	return nil
+}
+
+// netrcEntry is a machine entry or, without machine, the default entry.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+func parseNetrc(data string) []netrcEntry {
+	var entries []netrcEntry
+	var current *netrcEntry
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := netrcFields(line)
+		for j := 0; j < len(fields); j++ {
+			switch fields[j] {
+			case "machine", "default":
+				entries = append(entries, netrcEntry{})
+				current = &entries[len(entries)-1]
+				if fields[j] == "machine" && j+1 < len(fields) {
+					j++
+					current.machine = fields[j]
+				}
+			case "login", "password", "account":
+				if current == nil || j+1 >= len(fields) {
+					continue
+				}
+				j++
+				if fields[j-1] == "login" {
+					current.login = fields[j]
+				} else if fields[j-1] == "password" {
+					current.password = fields[j]
+				}
+			case "macdef":
+				// a macro lasts until the next empty line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+	return entries
+}
+
+// netrcFields splits a line at white space. A field in double quotes may contain white space
+// and the escapes \", \\, \n and \t.
+func netrcFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	inField, quoted, escaped := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			switch r {
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			}
+			field.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"' && (quoted || !inField):
+			quoted = !quoted
+			inField = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
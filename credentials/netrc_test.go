@@ -0,0 +1,90 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testNetrc = `# build servers
+machine jenkins-a.example.com login jc password 11aa22bb33cc
+machine JENKINS-B.example.com
+	login ci
+	password "two words \"quoted\""
+
+macdef init
+machine jenkins-c.example.com login macro password wrong
+default login evil password wrong
+
+machine jenkins-c.example.com login jc password c-token
+default login anonymous password guest
+default login second password ignored
+`
+
+func TestNetrcLookup(t *testing.T) {
+	path := filepath.Join(tempDir(t), ".netrc")
+	if err := ioutil.WriteFile(path, []byte(testNetrc), 0600); err != nil {
+		t.Fatal(err)
+	}
+	n := &Netrc{Path: path}
+	tests := []struct {
+		host string
+		want Credential
+	}{
+		{"jenkins-a.example.com", Credential{User: "jc", Token: "11aa22bb33cc"}},
+		// the port is ignored, the host compared case insensitively
+		{"jenkins-a.example.com:8443", Credential{User: "jc", Token: "11aa22bb33cc"}},
+		{"jenkins-b.example.com", Credential{User: "ci", Token: `two words "quoted"`}},
+		// the macro is no entry
+		{"jenkins-c.example.com", Credential{User: "jc", Token: "c-token"}},
+		// other hosts get the first default entry
+		{"jenkins-d.example.com", Credential{User: "anonymous", Token: "guest"}},
+		{"a.example.com", Credential{User: "anonymous", Token: "guest"}},
+	}
+	for _, test := range tests {
+		c, ok, err := n.Lookup("https", test.host)
+		if err != nil || !ok || c != test.want {
+			t.Errorf("Lookup(%s) = %+v, %v, %v, want %+v", test.host, c, ok, err, test.want)
+		}
+	}
+}
+
+func TestNetrcWithoutDefault(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, ".netrc")
+	if err := ioutil.WriteFile(path, []byte("machine jenkins-a login jc password t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok, err := (&Netrc{Path: path}).Lookup("https", "jenkins-b"); ok || err != nil {
+		t.Errorf("Lookup of an unknown host = %+v, %v, %v", c, ok, err)
+	}
+	if c, ok, err := (&Netrc{Path: filepath.Join(dir, "missing")}).Lookup("https", "jenkins-a"); ok || err != nil {
+		t.Errorf("Lookup in a missing file = %+v, %v, %v", c, ok, err)
+	}
+}
+
+func TestNetrcFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"machine a login b", []string{"machine", "a", "login", "b"}},
+		{"  password\t\"with space\"  ", []string{"password", "with space"}},
+		{`password "a\"b\\c\td"`, []string{"password", "a\"b\\c\td"}},
+		{`password p"w`, []string{"password", `p"w`}},
+		{`password ""`, []string{"password", ""}},
+	}
+	for _, test := range tests {
+		got := netrcFields(test.line)
+		if len(got) != len(test.want) {
+			t.Errorf("netrcFields(%q) = %q, want %q", test.line, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("netrcFields(%q) = %q, want %q", test.line, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Protector encrypts the content of the credential file.
+type Protector interface {
+	// Name is stored in the file to detect a file protected differently.
+	Name() string
+	Protect(plain []byte) ([]byte, error)
+	Unprotect(protected []byte) ([]byte, error)
+}
+
+// ErrWrongPassphrase is returned if the credential file cannot be decrypted.
+var ErrWrongPassphrase = errors.New("wrong passphrase or damaged file")
+
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+type passphraseProtector struct {
+	passphrase []byte
+}
+
+// Passphrase returns a Protector encrypting with AES-GCM and a key derived from the
+// passphrase with scrypt.
+func Passphrase(passphrase string) Protector {
+	return &passphraseProtector{passphrase: []byte(passphrase)}
+}
+
+func (p *passphraseProtector) Name() string {
+	return "passphrase"
+}
+
+// Protect returns the salt, the nonce and the sealed data.
+func (p *passphraseProtector) Protect(plain []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := p.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	protected := append(salt, nonce...)
+	return aead.Seal(protected, nonce, plain, nil), nil
+}
+
+func (p *passphraseProtector) Unprotect(protected []byte) ([]byte, error) {
+	if len(protected) < saltSize {
+		return nil, ErrWrongPassphrase
+	}
+	aead, err := p.aead(protected[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	protected = protected[saltSize:]
+	if len(protected) < aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	plain, err := aead.Open(nil, protected[:aead.NonceSize()], protected[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plain, nil
+}
+
+func (p *passphraseProtector) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(p.passphrase, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package credentials
+
+import "errors"
+
+// OSProtector is only available on Windows.
+func OSProtector() (Protector, error) {
+	return nil, errors.New("no protection by the operating system available, use a passphrase")
+}
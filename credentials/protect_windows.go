@@ -0,0 +1,73 @@
+package credentials
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+// cryptprotectUIForbidden fails instead of asking the user.
+const cryptprotectUIForbidden = 0x1
+
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(b)), data: &b[0]}
+}
+
+// bytes copies the data of a blob allocated by Windows and frees it.
+func (b *dataBlob) bytes() []byte {
+	if b.data == nil {
+		return nil
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(b.data)))
+	return append([]byte(nil), (*[1 << 30]byte)(unsafe.Pointer(b.data))[:b.size:b.size]...)
+}
+
+type dpapiProtector struct{}
+
+// OSProtector returns a Protector using the data protection API of Windows. Only the current
+// user on the current machine can decrypt the file.
+func OSProtector() (Protector, error) {
+	if err := procCryptProtectData.Find(); err != nil {
+		return nil, err
+	}
+	return dpapiProtector{}, nil
+}
+
+func (dpapiProtector) Name() string {
+	return "dpapi"
+}
+
+func (dpapiProtector) Protect(plain []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newBlob(plain))), 0, 0, 0, 0,
+		cryptprotectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
+
+func (dpapiProtector) Unprotect(protected []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newBlob(protected))), 0, 0, 0, 0,
+		cryptprotectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
@@ -0,0 +1,89 @@
+package credentials
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// urlPassword matches the password in the user info of a URL.
+var urlPassword = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]*:)[^/\s@]+@`)
+
+// Redactor replaces secrets in texts.
+type Redactor struct {
+	mutex   sync.RWMutex
+	secrets []string
+	added   []string
+}
+
+// SetSecrets replaces the secrets to redact except the ones added by AddSecret.
+func (r *Redactor) SetSecrets(secrets []string) {
+	var nonEmpty []string
+	for _, secret := range secrets {
+		if secret != "" {
+			nonEmpty = append(nonEmpty, secret)
+		}
+	}
+	r.mutex.Lock()
+	r.secrets = nonEmpty
+	r.mutex.Unlock()
+}
+
+// AddSecret adds a secret to redact, e.g. one not kept in the store.
+func (r *Redactor) AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, s := range r.added {
+		if s == secret {
+			return
+		}
+	}
+	r.added = append(r.added, secret)
+}
+
+// Redact replaces the secrets and passwords in URLs by asterisks.
+func (r *Redactor) Redact(s string) string {
+	r.mutex.RLock()
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, "****")
+	}
+	for _, secret := range r.added {
+		s = strings.ReplaceAll(s, secret, "****")
+	}
+	r.mutex.RUnlock()
+	return urlPassword.ReplaceAllString(s, "${1}****@")
+}
+
+type redactingWriter struct {
+	r       *Redactor
+	w       io.Writer
+	mutex   sync.Mutex
+	pending []byte
+}
+
+// Writer returns a writer redacting everything written to w. Text is passed on line by line,
+// so a secret split across writes is redacted as well.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &redactingWriter{r: r, w: w}
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	rw.pending = append(rw.pending, p...)
+	end := bytes.LastIndexByte(rw.pending, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	lines := string(rw.pending[:end+1])
+	rw.pending = append(rw.pending[:0], rw.pending[end+1:]...)
+	if _, err := io.WriteString(rw.w, rw.r.Redact(lines)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
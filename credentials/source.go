@@ -0,0 +1,36 @@
+package credentials
+
+// Source provides credentials for hosts without a stored credential.
+type Source interface {
+	// Lookup returns the credential for the host, false if the source has none.
+	Lookup(protocol, host string) (Credential, bool, error)
+	// Reject tells the source that the server refused the credential.
+	Reject(protocol, host string, c Credential) error
+}
+
+// Sources asks the sources in order.
+type Sources []Source
+
+func (s Sources) Lookup(protocol, host string) (Credential, bool, error) {
+	var firstErr error
+	for _, source := range s {
+		c, ok, err := source.Lookup(protocol, host)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if ok {
+			return c, true, nil
+		}
+	}
+	return Credential{}, false, firstErr
+}
+
+func (s Sources) Reject(protocol, host string, c Credential) error {
+	var firstErr error
+	for _, source := range s {
+		if err := source.Reject(protocol, host, c); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+// escalationSettings is stored as JSON in the "Escalation" setting.
+type escalationSettings struct {
+	// After is the number of minutes a job has to keep failing before the first reminder.
+	// 0 disables reminders, but not escalation.
+	After int
+	// Factor by which the time between two reminders grows. Defaults to 2.
+	Factor float64
+	// MaxInterval caps the time between two reminders in minutes. Defaults to 240.
+	MaxInterval int
+	// EscalateAfter is the number of minutes a failure stays unclaimed before the
+	// escalation sinks are notified. 0 disables escalation.
+	EscalateAfter int
+	// Webhook receives escalations as JSON by POST.
+	Webhook string
+	// Mail sends escalations to its recipients.
+	Mail mailSettings
+}
+
+func getEscalationSettings() escalationSettings {
+	settings := walk.App().Settings()
+	var escalation escalationSettings
+	escalationStr, ok := settings.Get("Escalation")
+	if !ok || escalationStr == "" {
+		return escalation
+	}
+	err := json.Unmarshal([]byte(escalationStr), &escalation)
+	if err != nil {
+		log.Println("getEscalationSettings:", err)
+	}
+	return escalation
+}
+
+type failureState struct {
+	since     time.Time
+	next      time.Time
+	interval  time.Duration
+	escalated bool
+}
+
+// escalator reminds of failures that nobody claimed at growing intervals and
+// escalates them to separate sinks after a longer time.
+type escalator struct {
+	mutex         sync.Mutex
+	after         time.Duration
+	factor        float64
+	maxInterval   time.Duration
+	escalateAfter time.Duration
+	sinks         []notifier
+	states        map[string]*failureState
+	remind        func(ev *jobEvent)
+	escalate      func(ev *jobEvent, sinks []notifier)
+	muted         func(ev *jobEvent) bool
+}
+
+// newEscalator returns nil if reminders and escalation are disabled. Reminders are passed to
+// remind, escalations to escalate together with the escalation sinks. Muted jobs are
+// escalated once they are no longer muted.
+func newEscalator(settings escalationSettings, remind func(ev *jobEvent), escalate func(ev *jobEvent, sinks []notifier),
+	muted func(ev *jobEvent) bool) *escalator {
+	if settings.After <= 0 && settings.EscalateAfter <= 0 {
+		return nil
+	}
+	if settings.Factor < 1 {
+		settings.Factor = 2
+	}
+	if settings.MaxInterval <= 0 {
+		settings.MaxInterval = 240
+	}
+	e := &escalator{
+		after:         time.Duration(settings.After) * time.Minute,
+		factor:        settings.Factor,
+		maxInterval:   time.Duration(settings.MaxInterval) * time.Minute,
+		escalateAfter: time.Duration(settings.EscalateAfter) * time.Minute,
+		states:        make(map[string]*failureState),
+		remind:        remind,
+		escalate:      escalate,
+		muted:         muted,
+	}
+	if e.escalateAfter > 0 {
+		if settings.Webhook != "" {
+			e.sinks = append(e.sinks, newWebhookNotifier(settings.Webhook))
+		}
+		if mail := newMailNotifier(settings.Mail); mail != nil {
+			e.sinks = append(e.sinks, mail)
+		}
+		if len(e.sinks) == 0 {
+			log.Println("escalation: no webhook or mail configured")
+		}
+	}
+	return e
+}
+
+// adopt takes over the failure states of the escalator it replaces, so reminders keep
+// their schedule when the settings change.
+func (e *escalator) adopt(old *escalator) {
+	old.mutex.Lock()
+	defer old.mutex.Unlock()
+	e.states = old.states
+}
+
+// Close stops the escalation sinks.
+func (e *escalator) Close() {
+	for _, sink := range e.sinks {
+		if c, ok := sink.(closer); ok {
+			c.Close()
+		}
+	}
+}
+
+func jobKey(j *job) string {
+	return j.Jenkins + "\x00" + j.Name
+}
+
+// check sends due reminders and escalations for the given jobs.
+func (e *escalator) check(jobs []*job, now time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	seen := make(map[string]bool, len(jobs))
+	var reminders, escalations []*jobEvent
+	for _, j := range jobs {
+		if j.LastCompletedBuild.Result != "FAILURE" {
+			continue
+		}
+		key := jobKey(j)
+		seen[key] = true
+		state, ok := e.states[key]
+		if !ok {
+			state = &failureState{since: now, next: now.Add(e.after), interval: e.after}
+			e.states[key] = state
+		}
+		if j.Claim != nil {
+			continue
+		}
+		failing := now.Sub(state.since)
+		if e.after > 0 && !now.Before(state.next) {
+			reminders = append(reminders, &jobEvent{
+				Time:      now,
+				Job:       j,
+				OldStatus: "FAILURE",
+				NewStatus: "FAILURE",
+				Severity:  severityError,
+				Message:   failureMessage("reminder", j, failing),
+			})
+			state.interval = time.Duration(float64(state.interval) * e.factor)
+			if state.interval > e.maxInterval {
+				state.interval = e.maxInterval
+			}
+			state.next = now.Add(state.interval)
+		}
+		if e.escalateAfter > 0 && !state.escalated && failing >= e.escalateAfter {
+			ev := &jobEvent{
+				Time:      now,
+				Job:       j,
+				OldStatus: "FAILURE",
+				NewStatus: "FAILURE",
+				Severity:  severityError,
+				Message:   failureMessage("escalation", j, failing),
+			}
+			if !e.muted(ev) {
+				state.escalated = true
+				escalations = append(escalations, ev)
+			}
+		}
+	}
+	for key := range e.states {
+		if !seen[key] {
+			delete(e.states, key)
+		}
+	}
+
+	for _, ev := range reminders {
+		e.remind(ev)
+	}
+	if len(e.sinks) > 0 {
+		for _, ev := range escalations {
+			e.escalate(ev, e.sinks)
+		}
+	}
+}
+
+func failureMessage(id string, j *job, failing time.Duration) string {
+	data := newMessageData(j)
+	data.PreviousStatus = "FAILURE"
+	data.Duration = notifyMessages().duration(failing)
+	return notifyMessages().format(id, data)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+// execHookSettings is stored as JSON in the "ExecHooks" setting.
+type execHookSettings struct {
+	// MaxConcurrent limits how many hook processes run at the same time. Defaults to 2.
+	MaxConcurrent int
+	Hooks         []execHook
+}
+
+type execHook struct {
+	Command string
+	Args    []string
+	// Jobs are regular expressions matched against the job name, empty matches all jobs.
+	Jobs []string
+	// Statuses are the new build results the hook runs for, empty matches all results.
+	Statuses []string
+	// Timeout in seconds after which the process is killed. Defaults to 30.
+	Timeout int
+}
+
+func getExecHookSettings() execHookSettings {
+	settings := walk.App().Settings()
+	var hooks execHookSettings
+	hooksStr, ok := settings.Get("ExecHooks")
+	if !ok || hooksStr == "" {
+		return hooks
+	}
+	err := json.Unmarshal([]byte(hooksStr), &hooks)
+	if err != nil {
+		log.Println("getExecHookSettings:", err)
+	}
+	return hooks
+}
+
+type execRule struct {
+	hook     execHook
+	patterns jobPatterns
+}
+
+func (r *execRule) matches(ev *jobEvent) bool {
+	if !matchesJob(r.patterns, ev.Job.Name) {
+		return false
+	}
+	if len(r.hook.Statuses) == 0 {
+		return true
+	}
+	return contains(r.hook.Statuses, ev.NewStatus)
+}
+
+type execRun struct {
+	rule *execRule
+	ev   *jobEvent
+	done func(err error)
+}
+
+// execNotifier runs user-defined programs for matching events. The event is passed as
+// JENKINS_* environment variables and as JSON on stdin.
+type execNotifier struct {
+	rules []*execRule
+	queue chan execRun
+	done  chan struct{}
+	guard queueGuard
+}
+
+// newExecNotifier returns nil if no hooks are configured.
+func newExecNotifier(settings execHookSettings) *execNotifier {
+	n := &execNotifier{
+		queue: make(chan execRun, 100),
+		done:  make(chan struct{}),
+	}
+	for _, hook := range settings.Hooks {
+		if hook.Command == "" {
+			continue
+		}
+		if hook.Timeout <= 0 {
+			hook.Timeout = 30
+		}
+		n.rules = append(n.rules, &execRule{
+			hook:     hook,
+			patterns: compileJobPatterns(hook.Jobs, "exec hook "+hook.Command),
+		})
+	}
+	if len(n.rules) == 0 {
+		return nil
+	}
+	workers := settings.MaxConcurrent
+	if workers <= 0 {
+		workers = 2
+	}
+	for i := 0; i < workers; i++ {
+		go n.work()
+	}
+	return n
+}
+
+func (n *execNotifier) Name() string {
+	return "exec"
+}
+
+// Notify runs the matching hooks for each job of the event. done is called once all of them
+// finished.
+func (n *execNotifier) Notify(ev *jobEvent, done func(err error)) {
+	var runs []execRun
+	for _, member := range ev.members() {
+		for _, rule := range n.rules {
+			if rule.matches(member) {
+				runs = append(runs, execRun{rule: rule, ev: member})
+			}
+		}
+	}
+	finished := joinDone(len(runs), done)
+	for _, run := range runs {
+		run.done = finished
+		err := n.guard.enqueue(func() bool {
+			select {
+			case n.queue <- run:
+				return true
+			default:
+				return false
+			}
+		})
+		if err != nil {
+			finished(fmt.Errorf("%s: %v", run.rule.hook.Command, err))
+		}
+	}
+}
+
+func (n *execNotifier) work() {
+	defer handlePanic()
+	for {
+		select {
+		case run := <-n.queue:
+			if err := run.rule.run(run.ev); err != nil {
+				run.done(fmt.Errorf("%s: %v", run.rule.hook.Command, err))
+			} else {
+				run.done(nil)
+			}
+		case <-n.done:
+			for {
+				select {
+				case run := <-n.queue:
+					run.done(errClosed)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close stops the workers once their running hooks are finished.
+func (n *execNotifier) Close() {
+	n.guard.close(func() {
+		close(n.done)
+	})
+}
+
+func (r *execRule) run(ev *jobEvent) error {
+	data := ev.data()
+	stdin, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.hook.Timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, r.hook.Command, r.hook.Args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	cmd.Env = append(os.Environ(),
+		"JENKINS_JOB="+data.Job,
+		"JENKINS_STATUS="+data.Status,
+		"JENKINS_PREVIOUS_STATUS="+data.PreviousStatus,
+		"JENKINS_URL="+data.URL,
+		"JENKINS_BUILD="+strconv.Itoa(data.Build),
+		"JENKINS_INSTANCE="+data.Instance,
+		"JENKINS_SEVERITY="+data.Severity,
+		"JENKINS_MESSAGE="+data.Message,
+	)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		log.Println("exec hook", r.hook.Command+":", scanner.Text())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("killed after %d seconds", r.hook.Timeout)
+	}
+	return err
+}
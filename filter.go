@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"JenkinsCheck/query"
+)
+
+// filterHelp is the tool tip of the filter boxes.
+const filterHelp = "Filter the jobs by name or by a query, e.g.\n" +
+	"status:failure instance:jenkins-b name:/^product-/ building:true age>2d\n" +
+	"Combine terms with AND, OR, NOT or -term and group them with parentheses."
+
+// queryItem returns the fields of j a filter query is evaluated on.
+func queryItem(j *job) *query.Item {
+	return &query.Item{
+		Name:         j.Name,
+		Status:       j.LastCompletedBuild.Result,
+		Instance:     j.Jenkins,
+		InstanceName: instanceName(j.Jenkins),
+		Building:     j.LastBuild.Building,
+		Claimed:      j.Claim != nil,
+		Muted:        j.Muted,
+		Groups:       j.Groups,
+		Finished:     j.LastCompletedBuild.Timestamp,
+	}
+}
+
+// parseFilter parses the text of a filter box. An invalid query matches the text as
+// substring of the job name.
+func parseFilter(filter string) (*query.Query, error) {
+	q, err := query.Parse(filter)
+	if err != nil {
+		q, _ = query.Parse(`name:"` + strings.ReplaceAll(filter, `"`, ``) + `"`)
+	}
+	return q, err
+}
+
+// filterJobs returns the jobs matching q.
+func filterJobs(jobs []*job, q *query.Query) []*job {
+	if q.Empty() {
+		return jobs
+	}
+	now := time.Now()
+	filtered := make([]*job, 0, len(jobs))
+	for _, j := range jobs {
+		if q.Match(queryItem(j), now) {
+			filtered = append(filtered, j)
+		}
+	}
+	return filtered
+}
+
+// setFilter shows only the jobs matching filter in the table.
+func (m *jobModel) setFilter(filter string) error {
+	q, err := parseFilter(filter)
+	m.filter.Store(q)
+	m.refilter()
+	m.PublishRowsReset()
+	return err
+}
+
+// refilter rebuilds the shown rows from the monitored jobs.
+func (m *jobModel) refilter() {
+	q, _ := m.filter.Load().(*query.Query)
+	collapsed, _ := m.collapsed.Load().(map[string]bool)
+	m.items = collapseGroups(filterJobs(m.jobs, q), collapsed)
+}
+
+// filtered reports whether the table shows only part of the monitored jobs or collapsed
+// groups.
+func (m *jobModel) filtered() bool {
+	q, _ := m.filter.Load().(*query.Query)
+	collapsed, _ := m.collapsed.Load().(map[string]bool)
+	return !q.Empty() || len(collapsed) > 0
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"JenkinsCheck/config"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+// collapseGroups replaces the jobs of collapsed groups by one row per group at the position
+// of the group's first job. The row shows the worst result of the jobs.
+func collapseGroups(jobs []*job, collapsed map[string]bool) []*job {
+	if len(collapsed) == 0 {
+		return jobs
+	}
+	rows := make([]*job, 0, len(jobs))
+	groupRows := make(map[string]*job)
+	counts := make(map[string]int)
+	for _, j := range jobs {
+		group := j.Group()
+		if !collapsed[group] {
+			rows = append(rows, j)
+			continue
+		}
+		row := groupRows[group]
+		if row == nil {
+			row = &job{
+				Groups:     []string{group},
+				GroupMuted: j.GroupMuted,
+				Collapsed:  true,
+			}
+			groupRows[group] = row
+			rows = append(rows, row)
+		}
+		counts[group]++
+		if statusRank[j.LastCompletedBuild.Result] > statusRank[row.LastCompletedBuild.Result] {
+			row.LastCompletedBuild.Result = j.LastCompletedBuild.Result
+		}
+		row.LastBuild.Building = row.LastBuild.Building || j.LastBuild.Building
+	}
+	for group, row := range groupRows {
+		row.Name = fmt.Sprintf("%s (%d jobs)", group, counts[group])
+	}
+	return rows
+}
+
+// collapseGroup shows the jobs of a group as a single row or expands it again.
+func (m *jobModel) collapseGroup(group string, collapse bool) {
+	if group == "" {
+		return
+	}
+	old, _ := m.collapsed.Load().(map[string]bool)
+	collapsed := make(map[string]bool, len(old)+1)
+	for g := range old {
+		collapsed[g] = true
+	}
+	if collapse {
+		collapsed[group] = true
+	} else {
+		delete(collapsed, group)
+	}
+	m.collapsed.Store(collapsed)
+	m.refilter()
+	m.PublishRowsReset()
+}
+
+// muteGroup turns the mute of a group on or off. A group only known from the tags of its
+// jobs is added to the groups.
+func (m *jobModel) muteGroup(group string, muted bool) {
+	if group == "" {
+		return
+	}
+	cfg := getConfig()
+	groups := append([]config.Group(nil), cfg.Groups...)
+	found := false
+	for i := range groups {
+		if groups[i].Name == group {
+			groups[i].Muted = muted
+			found = true
+		}
+	}
+	if !found {
+		groups = append(groups, config.Group{Name: group, Muted: muted})
+	}
+	settings := walk.App().Settings()
+	if err := config.PutGroups(settings, groups); err != nil {
+		log.Println("muteGroup:", err)
+		return
+	}
+	if err := settings.Save(); err != nil {
+		log.Println(err)
+	}
+	cfg = loadConfig()
+	for _, j := range m.jobs {
+		j.GroupMuted = cfg.GroupSettings(j.Jenkins, j.Name).Muted
+	}
+	if muted {
+		log.Println("group", group, "muted")
+	} else {
+		log.Println("group", group, "unmuted")
+	}
+	m.refilter()
+	m.PublishRowsReset()
+}
+
+// jobTags returns the groups a monitored job is tagged with.
+func jobTags(cfg *config.Config, j *job) []string {
+	for _, watched := range cfg.Jobs {
+		if watched.Name == j.Name && watched.Instance == j.Jenkins {
+			return watched.Groups
+		}
+	}
+	return nil
+}
+
+// setJobTags replaces the groups the job is tagged with.
+func (m *jobModel) setJobTags(j *job, tags []string) {
+	cfg := getConfig()
+	jobs := append([]config.Job(nil), cfg.Jobs...)
+	for i := range jobs {
+		if jobs[i].Name == j.Name && jobs[i].Instance == j.Jenkins {
+			jobs[i].Groups = tags
+		}
+	}
+	settings := walk.App().Settings()
+	if err := config.PutJobs(settings, jobs); err != nil {
+		log.Println("setJobTags:", err)
+		return
+	}
+	if err := settings.Save(); err != nil {
+		log.Println(err)
+	}
+	cfg = loadConfig()
+	groups := cfg.GroupSettings(j.Jenkins, j.Name)
+	j.Groups = groups.Groups
+	j.GroupMuted = groups.Muted
+	m.refilter()
+	m.PublishRowsReset()
+}
+
+// openGroupsDialog edits the groups the current job is tagged with.
+func (mw *jenkinsMainWindow) openGroupsDialog() {
+	defer handlePanic()
+	idx := mw.table.CurrentIndex()
+	if idx < 0 {
+		return
+	}
+	model := mw.table.Model().(*jobModel)
+	item := model.items[idx]
+
+	var dlg *walk.Dialog
+	var groupsBox *walk.LineEdit
+	var okPB, cancelPB *walk.PushButton
+	err := Dialog{
+		AssignTo:      &dlg,
+		Title:         "Groups of " + item.Name,
+		Icon:          mw.Icon(),
+		DefaultButton: &okPB,
+		CancelButton:  &cancelPB,
+		MinSize:       Size{Width: 400},
+		Layout:        VBox{},
+		Children: []Widget{
+			Label{Text: "Groups (separated by commas):"},
+			LineEdit{
+				AssignTo: &groupsBox,
+				Text:     strings.Join(jobTags(getConfig(), item), ", "),
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &okPB,
+						Text:     "Ok",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdOK)
+						},
+					},
+					PushButton{
+						AssignTo: &cancelPB,
+						Text:     "Cancel",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdCancel)
+						},
+					},
+				},
+			},
+		},
+	}.Create(mw)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if dlg.Run() != walk.DlgCmdOK {
+		return
+	}
+	var tags []string
+	for _, tag := range strings.Split(groupsBox.Text(), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" && !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	model.setJobTags(item, tags)
+}
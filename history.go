@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+type sinkResult struct {
+	Sink  string `json:"sink"`
+	Error string `json:"error,omitempty"`
+}
+
+// historyRecord is one line of the history file. Events are recorded once all sinks
+// finished, sinks like mail may take a while.
+type historyRecord struct {
+	eventData
+	// Outcome is empty for delivered events, otherwise the reason it was not delivered.
+	Outcome    string       `json:"outcome,omitempty"`
+	Deliveries []sinkResult `json:"deliveries,omitempty"`
+	// Group is the summary the event was delivered with, if it was grouped.
+	Group string `json:"group,omitempty"`
+}
+
+// DeliveryText describes the outcome for the history view.
+func (r *historyRecord) DeliveryText() string {
+	if r.Outcome != "" {
+		return r.Outcome
+	}
+	results := make([]string, len(r.Deliveries))
+	for i, d := range r.Deliveries {
+		if d.Error != "" {
+			results[i] = d.Sink + " failed: " + d.Error
+		} else {
+			results[i] = d.Sink
+		}
+	}
+	return strings.Join(results, ", ")
+}
+
+// history is an append-only file with one JSON record per line for every event.
+type history struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func newHistory(path string) *history {
+	return &history{path: path}
+}
+
+func (h *history) record(ev *jobEvent, outcome string, deliveries []sinkResult) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		log.Println("history:", err)
+		return
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	var group string
+	if ev.Job == nil {
+		group = ev.Message
+	}
+	for _, member := range ev.members() {
+		err = encoder.Encode(historyRecord{
+			eventData:  member.data(),
+			Outcome:    outcome,
+			Deliveries: deliveries,
+			Group:      group,
+		})
+		if err != nil {
+			log.Println("history:", err)
+			return
+		}
+	}
+}
+
+// historyQuery selects history records. Zero values match everything.
+type historyQuery struct {
+	Job         string
+	Instance    string
+	From        time.Time
+	To          time.Time
+	MinSeverity eventSeverity
+}
+
+func (q *historyQuery) matches(r *historyRecord) bool {
+	if q.Job != "" && r.Job != q.Job {
+		return false
+	}
+	if q.Instance != "" && r.Instance != q.Instance {
+		return false
+	}
+	if !q.From.IsZero() && r.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !r.Time.Before(q.To) {
+		return false
+	}
+	return parseSeverity(r.Severity) >= q.MinSeverity
+}
+
+func parseSeverity(s string) eventSeverity {
+	switch s {
+	case "error":
+		return severityError
+	case "warning":
+		return severityWarning
+	default:
+		return severityInfo
+	}
+}
+
+// query returns the matching records, oldest first. Unreadable lines are skipped.
+func (h *history) query(q historyQuery) ([]*historyRecord, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	file, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []*historyRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		record := new(historyRecord)
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			log.Println("history: skipping line:", err)
+			continue
+		}
+		if q.matches(record) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+type historyModel struct {
+	walk.ReflectTableModelBase
+	items []*historyRecord
+}
+
+func (m *historyModel) Items() interface{} {
+	return m.items
+}
+
+// openHistoryView shows the notification history of a job, newest first.
+func (mw *jenkinsMainWindow) openHistoryView(j *job) {
+	defer handlePanic()
+	h := mw.table.Model().(*jobModel).history
+	if h == nil {
+		return
+	}
+	records, err := h.query(historyQuery{Job: j.Name, Instance: j.Jenkins})
+	if err != nil {
+		log.Println(err)
+	}
+	for i, k := 0, len(records)-1; i < k; i, k = i+1, k-1 {
+		records[i], records[k] = records[k], records[i]
+	}
+	model := &historyModel{items: records}
+
+	var dlg *walk.Dialog
+	var closePB *walk.PushButton
+	err = Dialog{
+		AssignTo:      &dlg,
+		Title:         "Notification history of " + j.Name,
+		Icon:          mw.Icon(),
+		DefaultButton: &closePB,
+		CancelButton:  &closePB,
+		MinSize:       Size{Width: 1000, Height: 500},
+		Layout:        VBox{},
+		Children: []Widget{
+			TableView{
+				AlternatingRowBG: true,
+				Model:            model,
+				Columns: []TableViewColumn{
+					{Title: "Time", Name: "Time", Format: "2006-01-02 15:04:05", Width: 150},
+					{Title: "Build", Name: "Build"},
+					{Title: "Status", Name: "Status"},
+					{Title: "Severity", Name: "Severity"},
+					{Title: "Message", Name: "Message", Width: 400},
+					{Title: "Delivery", Name: "DeliveryText", Width: 200},
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &closePB,
+						Text:     "Ok",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdOK)
+						},
+					},
+				},
+			},
+		},
+	}.Create(mw)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	dlg.Run()
+}
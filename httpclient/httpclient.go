@@ -0,0 +1,102 @@
+// Package httpclient builds the HTTP transports of the Jenkins instances from their TLS,
+// proxy and timeout settings.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"JenkinsCheck/config"
+)
+
+// Defaults for instances without own timeouts.
+const (
+	DefaultConnectTimeout  = 3 * time.Second
+	DefaultResponseTimeout = 5 * time.Second
+	DefaultTimeout         = 3 * time.Minute
+)
+
+// NewTransport returns a transport with the settings of the instance.
+func NewTransport(instance config.Instance) (*http.Transport, error) {
+	if err := instance.Validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(instance)
+	if err != nil {
+		return nil, err
+	}
+	proxy := http.ProxyFromEnvironment
+	switch instance.Proxy {
+	case "":
+	case config.ProxyNone:
+		proxy = nil
+	default:
+		proxyURL, err := url.Parse(instance.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: tlsConfig,
+		// Cares about the setup timeout for upgrading the unencrypted connection to an encryped one HTTPS
+		TLSHandshakeTimeout: 5 * time.Second,
+		// Defines the setup timeout for an unencrypted HTTP connection
+		DialContext: (&net.Dialer{
+			Timeout:   seconds(instance.ConnectTimeout, DefaultConnectTimeout),
+			KeepAlive: 2 * time.Minute,
+		}).DialContext,
+		ExpectContinueTimeout: 5 * time.Second,
+		ResponseHeaderTimeout: seconds(instance.ResponseTimeout, DefaultResponseTimeout),
+	}, nil
+}
+
+// Timeout returns the time a whole request to the instance may take.
+func Timeout(instance config.Instance) time.Duration {
+	return seconds(instance.Timeout, DefaultTimeout)
+}
+
+func seconds(value int, fallback time.Duration) time.Duration {
+	if value == 0 {
+		return fallback
+	}
+	return time.Duration(value) * time.Second
+}
+
+func newTLSConfig(instance config.Instance) (*tls.Config, error) {
+	if instance.CABundle == "" && instance.ClientCert == "" && !instance.Insecure {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: instance.Insecure}
+	if instance.CABundle != "" {
+		pem, err := ioutil.ReadFile(instance.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("CA bundle: %w", err)
+		}
+		// without access to the system certificates only the bundle is trusted
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("CA bundle: no certificates found in " + instance.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if instance.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(instance.ClientCert, instance.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
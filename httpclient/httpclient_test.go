@@ -0,0 +1,155 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"JenkinsCheck/config"
+)
+
+func TestNewTransportInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance config.Instance
+		want     string
+	}{
+		{"missing CA bundle", config.Instance{CABundle: "testdata/missing.pem"}, "CA bundle"},
+		{"invalid CA bundle", config.Instance{CABundle: "testdata/invalid.pem"}, "no certificates found in testdata/invalid.pem"},
+		{"cert without key", config.Instance{ClientCert: "testdata/client.pem"}, "must be set together"},
+		{"key without cert", config.Instance{ClientKey: "testdata/client.key"}, "must be set together"},
+		{"key of another cert", config.Instance{ClientCert: "testdata/client.pem", ClientKey: "testdata/server.key"}, "client certificate"},
+		{"invalid proxy", config.Instance{Proxy: "proxy:8080"}, "proxy"},
+		{"negative timeout", config.Instance{ResponseTimeout: -1}, "negative"},
+	}
+	for _, test := range tests {
+		_, err := NewTransport(test.instance)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: NewTransport = %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestNewTransportSettings(t *testing.T) {
+	request, _ := http.NewRequest("GET", "http://jenkins-a/", nil)
+	tests := []struct {
+		name         string
+		instance     config.Instance
+		wantProxy    string
+		wantResponse time.Duration
+		wantTimeout  time.Duration
+	}{
+		{"defaults", config.Instance{}, "http://env-proxy:3128", DefaultResponseTimeout, DefaultTimeout},
+		{"no proxy", config.Instance{Proxy: config.ProxyNone}, "", DefaultResponseTimeout, DefaultTimeout},
+		{"own proxy and timeouts", config.Instance{Proxy: "http://proxy:8080", ResponseTimeout: 20, Timeout: 60},
+			"http://proxy:8080", 20 * time.Second, time.Minute},
+	}
+	// ProxyFromEnvironment reads the environment only once, so it is set for all tests
+	previous, ok := os.LookupEnv("HTTP_PROXY")
+	os.Setenv("HTTP_PROXY", "http://env-proxy:3128")
+	defer func() {
+		if ok {
+			os.Setenv("HTTP_PROXY", previous)
+		} else {
+			os.Unsetenv("HTTP_PROXY")
+		}
+	}()
+	for _, test := range tests {
+		transport, err := NewTransport(test.instance)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var proxy string
+		if transport.Proxy != nil {
+			proxyURL, err := transport.Proxy(request)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if proxyURL != nil {
+				proxy = proxyURL.String()
+			}
+		}
+		if proxy != test.wantProxy {
+			t.Errorf("%s: proxy %q, want %q", test.name, proxy, test.wantProxy)
+		}
+		if transport.ResponseHeaderTimeout != test.wantResponse {
+			t.Errorf("%s: response timeout %v, want %v", test.name, transport.ResponseHeaderTimeout, test.wantResponse)
+		}
+		if got := Timeout(test.instance); got != test.wantTimeout {
+			t.Errorf("%s: Timeout = %v, want %v", test.name, got, test.wantTimeout)
+		}
+		if transport.TLSClientConfig != nil {
+			t.Errorf("%s: own TLS configuration without TLS settings", test.name)
+		}
+	}
+}
+
+// TestNewTransportTLS requests a server that trusts only the test CA and requires a client
+// certificate.
+func TestNewTransportTLS(t *testing.T) {
+	serverCert, err := tls.LoadX509KeyPair("testdata/server.pem", "testdata/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, err := ioutil.ReadFile("testdata/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(caPEM)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		instance config.Instance
+		ok       bool
+	}{
+		{"bundle and client certificate",
+			config.Instance{CABundle: "testdata/ca.pem", ClientCert: "testdata/client.pem", ClientKey: "testdata/client.key"}, true},
+		{"insecure with client certificate",
+			config.Instance{Insecure: true, ClientCert: "testdata/client.pem", ClientKey: "testdata/client.key"}, true},
+		{"without bundle", config.Instance{ClientCert: "testdata/client.pem", ClientKey: "testdata/client.key"}, false},
+		{"without client certificate", config.Instance{CABundle: "testdata/ca.pem"}, false},
+	}
+	for _, test := range tests {
+		test.instance.Proxy = config.ProxyNone
+		transport, err := NewTransport(test.instance)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		client := &http.Client{Transport: transport, Timeout: Timeout(test.instance)}
+		resp, err := client.Get(server.URL)
+		if !test.ok {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s: request succeeded", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "client" {
+			t.Errorf("%s: the server saw the client %q", test.name, body)
+		}
+		transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"JenkinsCheck/adaptive"
+	"JenkinsCheck/backoff"
+	"JenkinsCheck/config"
+
+	"github.com/lxn/walk"
+)
+
+// backoffSettings is stored as JSON in the "Backoff" setting. Zero values take the defaults.
+type backoffSettings struct {
+	// Initial is the number of seconds an instance is not polled after it failed. It doubles
+	// with every further failure up to Max seconds.
+	Initial int
+	Max     int
+	// Jitter is the fraction the delays are spread randomly by. A negative value disables it.
+	Jitter float64
+	// Threshold is the number of failures in a row after which an instance is reported
+	// unreachable and only probed every Probe seconds.
+	Threshold int
+	Probe     int
+}
+
+func getBackoffSettings() backoffSettings {
+	settings := walk.App().Settings()
+	var b backoffSettings
+	backoffStr, ok := settings.Get("Backoff")
+	if !ok || backoffStr == "" {
+		return b
+	}
+	err := json.Unmarshal([]byte(backoffStr), &b)
+	if err != nil {
+		log.Println("getBackoffSettings:", err)
+	}
+	return b
+}
+
+func (b backoffSettings) policy() backoff.Policy {
+	p := backoff.Policy{
+		Initial:   time.Duration(b.Initial) * time.Second,
+		Max:       time.Duration(b.Max) * time.Second,
+		Jitter:    b.Jitter,
+		Threshold: b.Threshold,
+		Probe:     time.Duration(b.Probe) * time.Second,
+	}
+	switch {
+	case b.Jitter == 0:
+		p.Jitter = backoff.DefaultPolicy.Jitter
+	case b.Jitter < 0:
+		p.Jitter = 0
+	}
+	return p
+}
+
+// instanceHealth tracks the failures of the polled instances.
+var instanceHealth = backoff.New(backoff.DefaultPolicy)
+
+// setupBackoff applies the backoff settings and forgets instances no longer polled.
+func setupBackoff(cfg *config.Config) {
+	instanceHealth.SetPolicy(getBackoffSettings().policy())
+	urls := cfg.PollURLs()
+	instanceHealth.Forget(func(url string) bool {
+		return contains(urls, url)
+	})
+}
+
+// instancePaces adapts the poll intervals of the instances.
+var instancePaces = adaptive.New(pollLimits(config.Default()))
+
+func pollLimits(cfg *config.Config) adaptive.Limits {
+	min, max := cfg.IntervalLimits()
+	return adaptive.Limits{
+		Min:  time.Duration(min) * time.Second,
+		Base: time.Duration(cfg.Interval) * time.Second,
+		Max:  time.Duration(max) * time.Second,
+	}
+}
+
+// setupPacing applies the interval limits. All instances are polled at the next tick, as the
+// jobs are reloaded.
+func setupPacing(cfg *config.Config) {
+	instancePaces.Reset(pollLimits(cfg))
+}
+
+// pollJobs queries the jobs of the instances whose interval elapsed or that have jobs in
+// forced, unless they are backing off. Failures are logged once and instances becoming
+// unreachable or online again are reported as events.
+func (m *jobModel) pollJobs(urls []string, forced map[string]bool, notify bool) jobs {
+	polled := jobs{observed: make(map[string]*adaptive.Observation)}
+	for _, url := range urls {
+		now := time.Now()
+		if !instancePaces.Due(url, now) && !forced[url] {
+			polled.Skipped = append(polled.Skipped, url)
+			continue
+		}
+		if !instanceHealth.Allow(url, now) {
+			status := instanceHealth.Status(url)
+			polled.Jobs = append(polled.Jobs, &job{
+				Name: fmt.Sprintf("%c%s since %s, next try at %s (%s)", 9, status.State, status.Since.Format("15:04"),
+					status.Next.Format("15:04:05"), url),
+				Jenkins: url,
+			})
+			polled.Unreachable = append(polled.Unreachable, url)
+			polled.Skipped = append(polled.Skipped, url)
+			continue
+		}
+		j := getJobs(url)
+		polled.Jobs = append(polled.Jobs, j.Jobs...)
+		if j.retryAfter > 0 {
+			// a busy instance is not down, its jobs keep their state
+			log.Println(url, j.err, "retrying after", j.retryAfter)
+			polled.observed[url] = &adaptive.Observation{RetryAfter: j.retryAfter}
+			polled.Skipped = append(polled.Skipped, url)
+			continue
+		}
+		polled.Unreachable = append(polled.Unreachable, j.Unreachable...)
+		if j.err == nil {
+			polled.observed[url] = &adaptive.Observation{Latency: j.latency}
+		}
+		var change backoff.Change
+		var changed bool
+		if j.err != nil {
+			change, changed = instanceHealth.Failure(url, j.err, now)
+			if instanceHealth.Status(url).Failures == 1 {
+				log.Println(url, j.err)
+			}
+		} else {
+			change, changed = instanceHealth.Success(url, now)
+		}
+		if !changed {
+			continue
+		}
+		ev := newInstanceEvent(change, now)
+		log.Println(ev.Message)
+		if notify {
+			m.release(ev)
+		}
+	}
+	return polled
+}
+
+// groupsDue returns the URLs of the instances with jobs of groups whose interval elapsed.
+func groupsDue(cfg *config.Config, monitored []*job, now time.Time) map[string]bool {
+	due := make(map[string]bool)
+	for _, j := range monitored {
+		if interval := cfg.GroupSettings(j.Jenkins, j.Name).Interval; interval > 0 && j.due(interval, now) {
+			due[j.Jenkins] = true
+		}
+	}
+	return due
+}
+
+// skipped reports whether the instance was not queried.
+func (j *jobs) skipped(url string) bool {
+	return contains(j.Skipped, url)
+}
+
+// unreachable reports whether the instance did not answer or is backing off.
+func (j *jobs) unreachable(url string) bool {
+	return contains(j.Unreachable, url)
+}
+
+// answered reports whether at least one instance sent its jobs.
+func (j *jobs) answered() bool {
+	for _, o := range j.observed {
+		if o.RetryAfter == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// adaptPaces adapts the intervals of the queried instances to the activity of their jobs.
+func adaptPaces(polled jobs, monitored []*job, changed []*job, now time.Time) {
+	for url, o := range polled.observed {
+		for _, j := range monitored {
+			if j.Jenkins == url && (j.LastBuild.Building || j.InQueue) {
+				o.Active = true
+				break
+			}
+		}
+		for _, j := range changed {
+			if j.Jenkins == url {
+				o.Changed = true
+				break
+			}
+		}
+		instancePaces.Observe(url, *o, now)
+	}
+}
+
+// newInstanceEvent returns the event reporting an instance as unreachable or online again.
+func newInstanceEvent(change backoff.Change, now time.Time) *jobEvent {
+	name := instanceName(change.URL)
+	data := messageData{
+		Name:     name,
+		Instance: name,
+		Since:    change.Since.Format("15:04"),
+	}
+	ev := &jobEvent{
+		Time:     now,
+		Job:      &job{Name: name, Jenkins: change.URL, URL: change.URL},
+		Instance: true,
+	}
+	if change.State == backoff.Unreachable {
+		ev.OldStatus, ev.NewStatus = statusOnline, statusUnreachable
+		ev.Severity = severityWarning
+		ev.Message = notifyMessages().format("unreachable", data)
+	} else {
+		ev.OldStatus, ev.NewStatus = statusUnreachable, statusOnline
+		ev.Message = notifyMessages().format("backOnline", data)
+	}
+	return ev
+}
+
+// Statuses of instance events.
+const (
+	statusOnline      = "ONLINE"
+	statusUnreachable = "UNREACHABLE"
+)
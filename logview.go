@@ -133,7 +133,7 @@ func (mw *jenkinsMainWindow) openLogView(j *job) {
 func (lv *logview) LoadText() {
 	defer handlePanic()
 	lv.SetText("Getting build log...")
-	resp, err := http.Get(fmt.Sprint(lv.job.URL, "/", lv.job.LastBuild.Label, "/consoleText"))
+	resp, err := clientFor(lv.job.Jenkins).Get(fmt.Sprint(lv.job.URL, "/", lv.job.LastBuild.Label, "/consoleText"))
 	if resp != nil && resp.Body != nil {
 		defer resp.Body.Close()
 	}
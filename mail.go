@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"JenkinsCheck/smtpmail"
+
+	"github.com/lxn/walk"
+)
+
+// mailSettings is stored as JSON in the "Mail" setting.
+type mailSettings struct {
+	Host     string
+	Port     int
+	StartTLS bool
+	Username string
+	// Password should be left empty in favor of Credential, the id of a stored credential
+	// with the user name and the password as token.
+	Password   string
+	Credential string
+	From       string
+	// Digest is the number of minutes events are collected before one mail per recipient
+	// is sent. 0 sends a mail per event.
+	Digest     int
+	Recipients []mailRecipient
+}
+
+type mailRecipient struct {
+	Address string
+	// Jobs are regular expressions matched against the job name. An empty list subscribes
+	// to all jobs.
+	Jobs []string
+}
+
+func getMailSettings() mailSettings {
+	settings := walk.App().Settings()
+	var mail mailSettings
+	mailStr, ok := settings.Get("Mail")
+	if !ok || mailStr == "" {
+		return mail
+	}
+	err := json.Unmarshal([]byte(mailStr), &mail)
+	if err != nil {
+		log.Println("getMailSettings:", err)
+	}
+	mail.Username, mail.Password = notifierLogin("mail", mail.Credential, mail.Username, mail.Password)
+	return mail
+}
+
+type mailSubscriber struct {
+	address  string
+	patterns jobPatterns
+}
+
+// mailNotifier sends events by SMTP, either one mail per event or as a periodic digest.
+type mailNotifier struct {
+	settings    mailSettings
+	subscribers []*mailSubscriber
+	queue       chan queuedEvent
+	done        chan struct{}
+	guard       queueGuard
+}
+
+// newMailNotifier returns nil if mail is not configured.
+func newMailNotifier(settings mailSettings) *mailNotifier {
+	if settings.Host == "" || settings.From == "" || len(settings.Recipients) == 0 {
+		return nil
+	}
+	if settings.Port == 0 {
+		settings.Port = 25
+		if settings.StartTLS {
+			settings.Port = 587
+		}
+	}
+	n := &mailNotifier{
+		settings: settings,
+		queue:    make(chan queuedEvent, 100),
+		done:     make(chan struct{}),
+	}
+	for _, recipient := range settings.Recipients {
+		n.subscribers = append(n.subscribers, &mailSubscriber{
+			address:  recipient.Address,
+			patterns: compileJobPatterns(recipient.Jobs, "mail "+recipient.Address),
+		})
+	}
+	go n.run()
+	return n
+}
+
+func (n *mailNotifier) Name() string {
+	return "mail"
+}
+
+func (n *mailNotifier) Notify(ev *jobEvent, done func(err error)) {
+	err := n.guard.enqueue(func() bool {
+		select {
+		case n.queue <- queuedEvent{ev: ev, done: done}:
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil {
+		done(err)
+	}
+}
+
+func (n *mailNotifier) run() {
+	defer handlePanic()
+	var flush <-chan time.Time
+	if n.settings.Digest > 0 {
+		ticker := time.NewTicker(time.Duration(n.settings.Digest) * time.Minute)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+	var pending []queuedEvent
+	for {
+		select {
+		case queued := <-n.queue:
+			if flush != nil {
+				pending = append(pending, queued)
+				continue
+			}
+			n.deliver([]queuedEvent{queued})
+		case <-flush:
+			if len(pending) > 0 {
+				n.deliver(pending)
+				pending = nil
+			}
+		case <-n.done:
+			if len(pending) > 0 {
+				n.deliver(pending)
+			}
+			drain(n.queue)
+			return
+		}
+	}
+}
+
+// Close stops the notifier after sending the pending digest.
+func (n *mailNotifier) Close() {
+	n.guard.close(func() {
+		close(n.done)
+	})
+}
+
+// deliver sends the events to their subscribers and reports the first failure of each event.
+func (n *mailNotifier) deliver(queued []queuedEvent) {
+	errs := make([]error, len(queued))
+	for _, subscriber := range n.subscribers {
+		var matched []*jobEvent
+		var sources []int
+		for i, q := range queued {
+			for _, member := range q.ev.members() {
+				if matchesJob(subscriber.patterns, member.Job.Name) {
+					matched = append(matched, member)
+					sources = append(sources, i)
+				}
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		subject := notifyMessages().format("mailSubject", messageData{Count: len(matched)})
+		if len(matched) == 1 {
+			subject = matched[0].Message
+		} else if len(queued) == 1 && len(matched) == len(queued[0].ev.Events) {
+			subject = queued[0].ev.Message
+		}
+		msg, err := composeMail(n.settings.From, subscriber.address, subject, matched)
+		if err == nil {
+			err = n.send(subscriber.address, msg)
+		}
+		if err != nil {
+			err = fmt.Errorf("sending to %s: %v", subscriber.address, err)
+			for _, i := range sources {
+				if errs[i] == nil {
+					errs[i] = err
+				}
+			}
+		}
+	}
+	for i, q := range queued {
+		q.done(errs[i])
+	}
+}
+
+func (n *mailNotifier) send(to string, msg []byte) error {
+	return smtpmail.Server{
+		Host:     n.settings.Host,
+		Port:     n.settings.Port,
+		StartTLS: n.settings.StartTLS,
+		Username: n.settings.Username,
+		Password: n.settings.Password,
+	}.Send(n.settings.From, to, msg)
+}
+
+// composeMail builds the mail listing the events.
+func composeMail(from, to, subject string, events []*jobEvent) ([]byte, error) {
+	entries := make([]smtpmail.Entry, len(events))
+	for i, ev := range events {
+		entries[i] = smtpmail.Entry{Time: ev.Time, Status: ev.NewStatus, Message: ev.Message, URL: ev.Job.URL}
+	}
+	return smtpmail.Compose(walk.App().ProductName(), from, to, subject, entries, time.Now())
+}
@@ -12,10 +12,13 @@ import (
 	"os/exec"
 	"path"
 	"runtime/debug"
-	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"JenkinsCheck/config"
+	"JenkinsCheck/scheduler"
+
 	"github.com/lxn/walk"
 	. "github.com/lxn/walk/declarative"
 	"github.com/lxn/win"
@@ -44,7 +47,7 @@ func main() {
 	}
 	defer logger.Close()
 	multiLogger := io.MultiWriter(logger, os.Stdout)
-	log.SetOutput(multiLogger)
+	log.SetOutput(redactor.Writer(multiLogger))
 	proxy := http.ProxyFromEnvironment
 	trans := &http.Transport{
 		Proxy: proxy,
@@ -61,7 +64,7 @@ func main() {
 		// And with this parameter you set how long the complete transfer of the header is allowed to last
 		ResponseHeaderTimeout: 5 * time.Second,
 	}
-	http.DefaultTransport = trans
+	http.DefaultTransport = &authTransport{base: trans}
 	// Prevent endless redirects
 	http.DefaultClient.Timeout = 3 * time.Minute
 
@@ -76,14 +79,19 @@ func main() {
 	app := walk.App()
 	app.SetOrganizationName(company)
 	app.SetProductName(appName)
-	settings := walk.NewIniFileSettings("settings.ini")
+	settings := newFileSettings(walk.NewIniFileSettings("settings.ini"))
 	err = settings.Load()
 	if err != nil {
 		log.Fatal(err)
 	}
 	app.SetSettings(settings)
+	openCredentialStore(settings)
+	// migrations querying the instances would block the start, they run once the window is up
+	migrationDeferred := migrateSettings(settings, nil)
+	cfg := loadConfig()
 
 	tableModel := new(jobModel)
+	var filterBox *walk.LineEdit
 
 	MainWindow{
 		AssignTo: &mainWindow.MainWindow,
@@ -93,6 +101,11 @@ func main() {
 		Size:     Size{Width: 900, Height: 800},
 		Font:     Font{Family: "Calibri", PointSize: 12},
 		MenuItems: []MenuItem{
+			Action{
+				Text:        "&Refresh now",
+				Shortcut:    Shortcut{Key: walk.KeyF5},
+				OnTriggered: mainWindow.refreshNow,
+			},
 			Menu{
 				Text: "&Settings",
 				Items: []MenuItem{
@@ -100,6 +113,28 @@ func main() {
 						Text:        "Settings",
 						OnTriggered: mainWindow.openSettings,
 					},
+					Action{
+						Text:        "Export config file...",
+						OnTriggered: mainWindow.exportConfigFile,
+					},
+					Separator{},
+					Action{
+						Text:        "Import watch list...",
+						OnTriggered: mainWindow.importWatchList,
+					},
+					Action{
+						Text:        "Export watch list...",
+						OnTriggered: mainWindow.exportWatchList,
+					},
+					Action{
+						Text:        "Subscribe to watch list...",
+						OnTriggered: mainWindow.openSubscriptionDialog,
+					},
+					Action{
+						Text:        "Credentials...",
+						OnTriggered: mainWindow.openCredentialsDialog,
+					},
+					Separator{},
 					Action{
 						Text:        "E&xit",
 						OnTriggered: doExit,
@@ -107,8 +142,33 @@ func main() {
 				},
 			},
 		},
-		Layout: HBox{},
+		Layout: VBox{},
 		Children: []Widget{
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					Label{Text: "Filter:"},
+					LineEdit{
+						AssignTo:    &filterBox,
+						ToolTipText: filterHelp,
+						OnTextChanged: func() {
+							if err := tableModel.setFilter(filterBox.Text()); err != nil {
+								filterBox.SetToolTipText(err.Error() + "\n\n" + filterHelp)
+							} else {
+								filterBox.SetToolTipText(filterHelp)
+							}
+						},
+					},
+					PushButton{
+						Text:        "x",
+						ToolTipText: "Empty filter box",
+						MaxSize:     Size{Width: 20, Height: 10},
+						OnClicked: func() {
+							filterBox.SetText("")
+						},
+					},
+				},
+			},
 			TableView{
 				AssignTo:         &mainWindow.table,
 				Name:             "tableView",
@@ -149,6 +209,122 @@ func main() {
 							openInBrowser(tableModel.items[mainWindow.table.CurrentIndex()].URL)
 						},
 						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("!tableView.CurrentItem.Collapsed"),
+					},
+					Action{
+						Text: "Notification history",
+						OnTriggered: func() {
+							mainWindow.openHistoryView(tableModel.items[mainWindow.table.CurrentIndex()])
+						},
+						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("!tableView.CurrentItem.Collapsed"),
+					},
+					Separator{},
+					Action{
+						Text:        "Claim...",
+						OnTriggered: mainWindow.openClaimDialog,
+						Enabled:     Bind("tableView.HasCurrentItem"),
+						Visible:     Bind("!tableView.CurrentItem.Claimed && !tableView.CurrentItem.Collapsed"),
+					},
+					Action{
+						Text: "Release claim",
+						OnTriggered: func() {
+							if idx := mainWindow.table.CurrentIndex(); idx >= 0 {
+								go tableModel.releaseClaim(tableModel.items[idx])
+							}
+						},
+						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("tableView.CurrentItem.Claimed"),
+					},
+					Menu{
+						Text:    "Mute",
+						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("!tableView.CurrentItem.Collapsed"),
+						Items: []MenuItem{
+							Action{
+								Text: "For 1 hour",
+								OnTriggered: func() {
+									tableModel.muteJob(mainWindow.table.CurrentIndex(), time.Now().Add(time.Hour), false)
+								},
+							},
+							Action{
+								Text: "For 8 hours",
+								OnTriggered: func() {
+									tableModel.muteJob(mainWindow.table.CurrentIndex(), time.Now().Add(8*time.Hour), false)
+								},
+							},
+							Action{
+								Text: "For 1 day",
+								OnTriggered: func() {
+									tableModel.muteJob(mainWindow.table.CurrentIndex(), time.Now().Add(24*time.Hour), false)
+								},
+							},
+							Action{
+								Text: "For 1 week",
+								OnTriggered: func() {
+									tableModel.muteJob(mainWindow.table.CurrentIndex(), time.Now().Add(7*24*time.Hour), false)
+								},
+							},
+							Action{
+								Text: "Until the status changes",
+								OnTriggered: func() {
+									tableModel.muteJob(mainWindow.table.CurrentIndex(), time.Time{}, true)
+								},
+							},
+							Action{
+								Text: "Until turned off",
+								OnTriggered: func() {
+									tableModel.muteJob(mainWindow.table.CurrentIndex(), time.Time{}, false)
+								},
+							},
+						},
+					},
+					Action{
+						Text: "Unmute",
+						OnTriggered: func() {
+							tableModel.unmuteJob(mainWindow.table.CurrentIndex())
+						},
+						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("tableView.CurrentItem.Muted"),
+					},
+					Separator{},
+					Action{
+						Text:        "Groups...",
+						OnTriggered: mainWindow.openGroupsDialog,
+						Enabled:     Bind("tableView.HasCurrentItem"),
+						Visible:     Bind("!tableView.CurrentItem.Collapsed && !tableView.CurrentItem.Dynamic"),
+					},
+					Action{
+						Text: "Collapse group",
+						OnTriggered: func() {
+							tableModel.collapseGroup(tableModel.items[mainWindow.table.CurrentIndex()].Group(), true)
+						},
+						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("tableView.CurrentItem.Group != '' && !tableView.CurrentItem.Collapsed"),
+					},
+					Action{
+						Text: "Expand group",
+						OnTriggered: func() {
+							tableModel.collapseGroup(tableModel.items[mainWindow.table.CurrentIndex()].Group(), false)
+						},
+						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("tableView.CurrentItem.Collapsed"),
+					},
+					Action{
+						Text: "Mute group",
+						OnTriggered: func() {
+							tableModel.muteGroup(tableModel.items[mainWindow.table.CurrentIndex()].Group(), true)
+						},
+						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("tableView.CurrentItem.Group != '' && !tableView.CurrentItem.GroupMuted"),
+					},
+					Action{
+						Text: "Unmute group",
+						OnTriggered: func() {
+							tableModel.muteGroup(tableModel.items[mainWindow.table.CurrentIndex()].Group(), false)
+						},
+						Enabled: Bind("tableView.HasCurrentItem"),
+						Visible: Bind("tableView.CurrentItem.GroupMuted"),
 					},
 				},
 				Columns: []TableViewColumn{
@@ -176,6 +352,26 @@ func main() {
 						Format: "2006-01-02 15:04:05",
 						Width:  150,
 					},
+					{
+						Title: "Stale",
+						Name:  "StaleText",
+						Width: 100,
+					},
+					{
+						Title: "Muted",
+						Name:  "Muted",
+						Width: 60,
+					},
+					{
+						Title: "Claimed by",
+						Name:  "ClaimText",
+						Width: 200,
+					},
+					{
+						Title: "Group",
+						Name:  "GroupText",
+						Width: 100,
+					},
 					{
 						Title: "Jenkins URL",
 						Name:  "Jenkins",
@@ -237,6 +433,11 @@ func main() {
 								canvas.DrawText("🔨", boldFont, walk.RGB(0, 0, 0), style.Bounds(), 127)
 							}
 						}
+					case "Muted":
+						canvas := style.Canvas()
+						if (item.Muted || item.GroupMuted) && canvas != nil {
+							canvas.DrawText("🔕", boldFont, walk.RGB(0, 0, 0), style.Bounds(), 127)
+						}
 					default:
 						style.Font = mainWindow.table.Font()
 					}
@@ -247,7 +448,9 @@ func main() {
 						return
 					}
 					currentItem := tableModel.items[idx]
-					if currentItem.LastBuild.Label != 0 {
+					if currentItem.Collapsed {
+						tableModel.collapseGroup(currentItem.Group(), false)
+					} else if currentItem.LastBuild.Label != 0 {
 						mainWindow.openLogView(currentItem)
 					} else {
 						openInBrowser(currentItem.URL)
@@ -283,29 +486,32 @@ func main() {
 	exitAction.Triggered().Attach(doExit)
 	ni.ContextMenu().Actions().Add(exitAction)
 
-	go tableModel.initJobs(ni)
+	tableModel.history = newHistory(path.Join(logDir, "history.jsonl"))
+	tableModel.snapshot = path.Join(logDir, snapshotFileName)
+	tableModel.mutes = loadMutes()
+	tableModel.claims = loadClaims()
+	tableModel.ni = ni
+	tableModel.tray = newTrayIcon(ni, mainWindow, icon)
+	tableModel.setupPipeline()
+	warnInsecure(ni, setupClients(cfg))
+	setupBackoff(cfg)
+	setupPacing(cfg)
 
-	interval := getInterval()
+	mainWindow.poller = scheduler.New(scheduler.RealClock, time.Duration(cfg.PollInterval())*time.Second, tableModel.poll)
+	mainWindow.poller.Start()
+	if migrationDeferred {
+		go mainWindow.migrateDeferred(settings)
+	}
 
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
-	quit := make(chan bool, 1)
-	go func() {
-		defer handlePanic()
-		for {
-			select {
-			case <-ticker.C:
-				tableModel.updateJobs(ni)
-			case <-quit:
-				ticker.Stop()
-				break
-			}
-		}
-	}()
-	defer close(quit)
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go settings.watch(mainWindow, stopWatching)
+	mainWindow.refreshSubscription = make(chan struct{}, 1)
+	go mainWindow.subscribe(mainWindow.refreshSubscription, stopWatching)
 
 	walk.InitWrapperWindow(mainWindow)
 	mainWindow.Run()
-	quit <- true
+	mainWindow.poller.Stop()
 	log.Println("saving settings")
 	if err := settings.Save(); err != nil {
 		log.Fatal(err)
@@ -315,28 +521,83 @@ func main() {
 
 type jobModel struct {
 	walk.SortedReflectTableModelBase
-	items []*job
+	// jobs are all monitored jobs, items the ones shown by the filter.
+	jobs      []*job
+	items     []*job
+	filter    atomic.Value
+	collapsed atomic.Value
+	pipe      atomic.Value
+	ni        *walk.NotifyIcon
+	tray      *trayIcon
+	mutes     *muteList
+	claims    *claimList
+	history   *history
+	aggregate aggregateState
+	// snapshot is the path of the last known state of the jobs.
+	snapshot string
+	// loaded is set after the first poll, reload and pollAll are set to 1 to reload the jobs
+	// or to poll all instances at the next poll.
+	loaded  bool
+	reload  int32
+	pollAll int32
 }
 
 func (m *jobModel) Items() interface{} {
 	return m.items
 }
 
-func (m *jobModel) initJobs(ni *walk.NotifyIcon) {
+// poll is run by the scheduler. The first poll and the first one after the settings changed
+// load the jobs, the others update them inside the polling hours or on request.
+func (m *jobModel) poll(now time.Time) {
+	defer handlePanic()
+	switch {
+	case !m.loaded:
+		m.loaded = true
+		m.initJobs(true)
+	case atomic.SwapInt32(&m.reload, 0) == 1:
+		m.initJobs(false)
+	case atomic.SwapInt32(&m.pollAll, 0) == 1:
+		m.updateJobs(true, true)
+	case m.pipeline().polling.active(now):
+		m.updateJobs(true, false)
+	}
+}
+
+// initJobs loads the jobs with their last known state and polls them. Transitions since the
+// snapshot are reported if notify is set.
+func (m *jobModel) initJobs(notify bool) {
 	defer handlePanic()
-	m.items, _ = loadJobs()
+	m.jobs = loadJobs()
+	restoreSnapshot(m.snapshot, m.jobs)
+	m.refilter()
 	m.PublishRowsReset()
-	m.updateJobs(ni)
+	m.updateJobs(notify, true)
 }
 
-func (m *jobModel) updateJobs(ni *walk.NotifyIcon) {
-	jenkinsURLs := getJobsURLs()
-	jobs := getJobsFromMultiple(jenkinsURLs)
-	items := make([]*job, len(m.items))
-	copy(items, m.items)
+// updateJobs polls the instances that are due, or all of them, and applies the changes.
+func (m *jobModel) updateJobs(notify, all bool) {
+	cfg := getConfig()
+	jenkinsURLs := cfg.PollURLs()
+	now := time.Now()
+	forced := groupsDue(cfg, m.jobs, now)
+	if all {
+		for _, url := range jenkinsURLs {
+			forced[url] = true
+		}
+	}
+	jobs := m.pollJobs(jenkinsURLs, forced, notify)
+	reset := m.applyRules(cfg, jobs)
+	groups := make([]config.GroupSettings, len(m.jobs))
+	items := make([]*job, len(m.jobs))
+	copy(items, m.jobs)
 	for i := 0; i < len(items); i++ {
+		groups[i] = cfg.GroupSettings(items[i].Jenkins, items[i].Name)
+		if jobs.skipped(items[i].Jenkins) || jobs.unreachable(items[i].Jenkins) || !items[i].due(groups[i].Interval, now) {
+			continue
+		}
 		found := false
-		oldJob := m.items[i]
+		oldJob := m.jobs[i]
+		oldJob.polled = now
 		var newJob *job
 		for j := 0; j < len(jobs.Jobs); j++ {
 			if items[i].Name == jobs.Jobs[j].Name &&
@@ -348,52 +609,21 @@ func (m *jobModel) updateJobs(ni *walk.NotifyIcon) {
 		}
 		if !found {
 			items[i] = &job{
-				Name: m.items[i].Name,
-				URL:  strings.ReplaceAll(strings.ToLower(jenkinsURLs[0]), "/cc.xml", "/job/"+m.items[i].Name),
+				Name: m.jobs[i].Name,
+				URL:  strings.ReplaceAll(strings.ToLower(jenkinsURLs[0]), "/cc.xml", "/job/"+m.jobs[i].Name),
 			}
 		} else {
 			items[i] = newJob
-			oldStatus := oldJob.LastCompletedBuild.Result
-			newStatus := newJob.LastCompletedBuild.Result
-			if ni != nil && oldJob.LastCompletedBuild.Label < newJob.LastCompletedBuild.Label {
-				appName := walk.App().ProductName()
-				switch oldStatus {
-				case "SUCCESS":
-					switch newStatus {
-					case "SUCCESS":
-						if getSuccessiveSuccessful() {
-							ni.ShowInfo(appName, newJob.Name+" is still successful.")
-						}
-					case "UNSTABLE":
-						ni.ShowWarning(appName, newJob.Name+" has become unstable.")
-					case "FAILURE":
-						ni.ShowError(appName, newJob.Name+" failed.")
-					}
-				case "UNSTABLE":
-					switch newStatus {
-					case "SUCCESS":
-						ni.ShowInfo(appName, newJob.Name+" is successful again.")
-					case "UNSTABLE":
-						ni.ShowWarning(appName, newJob.Name+" is still unstable.")
-					case "FAILURE":
-						ni.ShowError(appName, newJob.Name+" failed.")
-					}
-				case "FAILURE":
-					switch newStatus {
-					case "SUCCESS":
-						ni.ShowInfo(appName, newJob.Name+" is successful again.")
-					case "UNSTABLE":
-						ni.ShowWarning(appName, newJob.Name+" is at least unstable now.")
-					case "FAILURE":
-						ni.ShowError(appName, newJob.Name+" still failing.")
-					}
+			if notify {
+				if ev := newJobEvent(oldJob, newJob); ev != nil {
+					m.dispatch(ev)
 				}
 			}
 		}
 	}
 
 	var changedIdx []int
-	for idx, item := range m.items {
+	for idx, item := range m.jobs {
 		var foundItem *job
 		for _, item2 := range items {
 			if item.Name == item2.Name && item.Jenkins == item2.Jenkins {
@@ -415,13 +645,67 @@ func (m *jobModel) updateJobs(ni *walk.NotifyIcon) {
 				item.URL = foundItem.URL
 				changed = true
 			}
+			if item.InQueue != foundItem.InQueue {
+				item.InQueue = foundItem.InQueue
+				changed = true
+			}
+			// fresh data ends a stale state, an unreachable instance starts one
+			stale := item.Stale
+			if foundItem != item {
+				stale = time.Time{}
+			} else if jobs.unreachable(item.Jenkins) && stale.IsZero() {
+				stale = item.polled
+			}
+			if !stale.Equal(item.Stale) {
+				item.Stale = stale
+				changed = true
+			}
+			if muted := m.mutes.isMuted(item, now); item.Muted != muted {
+				item.Muted = muted
+				changed = true
+			}
+			if c := m.claims.claimOf(item); !sameClaim(item.Claim, c) {
+				item.Claim = c
+				changed = true
+			}
+			if !equalStrings(item.Groups, groups[idx].Groups) || item.GroupMuted != groups[idx].Muted {
+				item.Groups = groups[idx].Groups
+				item.GroupMuted = groups[idx].Muted
+				changed = true
+			}
 			if changed {
 				changedIdx = append(changedIdx, idx)
 			}
 		}
 	}
 
-	if len(changedIdx) <= 5 {
+	changed := make([]*job, len(changedIdx))
+	for i, idx := range changedIdx {
+		changed[i] = m.jobs[idx]
+	}
+	adaptPaces(jobs, m.jobs, changed, now)
+	if jobs.answered() {
+		if err := writeSnapshot(m.snapshot, m.jobs); err != nil {
+			log.Println("snapshot:", err)
+		}
+	}
+
+	state := newAggregateState(m.jobs, jobs.Unreachable)
+	if len(changedIdx) > 0 || !state.equal(&m.aggregate) {
+		m.aggregate = state
+		for _, observer := range m.pipeline().observers {
+			observer.JobsChanged(changed, state)
+		}
+	}
+
+	if e := m.pipeline().escalator; e != nil {
+		e.check(m.jobs, now)
+	}
+
+	if reset || m.filtered() && len(changedIdx) > 0 {
+		m.refilter()
+		m.PublishRowsReset()
+	} else if len(changedIdx) <= 5 {
 		for _, idx := range changedIdx {
 			m.PublishRowChanged(idx)
 		}
@@ -431,19 +715,15 @@ func (m *jobModel) updateJobs(ni *walk.NotifyIcon) {
 }
 
 func openInBrowser(url string) {
-	settings := walk.App().Settings()
-	exe, ok := settings.Get("Browser")
-	if !ok || exe == "" {
-		exe = "explorer.exe" // default browser
-	}
-	cmd := exec.Command(exe, url)
+	cmd := exec.Command(getConfig().BrowserCommand(), url)
 	cmd.Start()
 }
 
 type jenkinsMainWindow struct {
 	*walk.MainWindow
-	table  *walk.TableView
-	ticker *time.Ticker
+	table               *walk.TableView
+	poller              *scheduler.Scheduler
+	refreshSubscription chan struct{}
 }
 
 func doExit() {
@@ -454,16 +734,23 @@ func doExit() {
 }
 
 func (mw *jenkinsMainWindow) reInit() {
-	settings := walk.App().Settings()
-	var interval int
-	intervalStr, ok := settings.Get("Interval")
-	if !ok {
-		interval = 10
-	} else {
-		interval, _ = strconv.Atoi(intervalStr)
-	}
-	mw.ticker = time.NewTicker(time.Duration(interval) * time.Second)
-	mw.table.Model().(*jobModel).initJobs(nil)
+	cfg := loadConfig()
+	hostCredentials.clear()
+	model := mw.table.Model().(*jobModel)
+	warnInsecure(model.ni, setupClients(cfg))
+	setupBackoff(cfg)
+	setupPacing(cfg)
+	model.setupPipeline()
+	atomic.StoreInt32(&model.reload, 1)
+	mw.poller.Reconfigure(time.Duration(cfg.PollInterval()) * time.Second)
+	mw.poller.TriggerNow()
+}
+
+// refreshNow polls all instances at once.
+func (mw *jenkinsMainWindow) refreshNow() {
+	model := mw.table.Model().(*jobModel)
+	atomic.StoreInt32(&model.pollAll, 1)
+	mw.poller.TriggerNow()
 }
 
 func (mw *jenkinsMainWindow) WndProc(hwnd win.HWND, msg uint32, wParam, lParam uintptr) uintptr {
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+	"unsafe"
+
+	"github.com/lxn/walk"
+)
+
+// messageCatalogs are the bundled notification texts as text/template sources by language
+// and message id. Missing ids fall back to English.
+var messageCatalogs = map[string]map[string]string{
+	"en": {
+		"details": `{{if .Tests.Failed}} {{.Tests.Failed}} of {{.Tests.Total}} tests failed.{{end}}` +
+			`{{if .Culprits}} Changes by {{join .Culprits ", "}}.{{end}}`,
+		"stillSuccessful": `{{.Name}} is still successful.`,
+		"unstable":        `{{.Name}} has become unstable.{{template "details" .}}`,
+		"failed":          `{{.Name}} failed.{{template "details" .}}`,
+		"successfulAgain": `{{.Name}} is successful again.`,
+		"stillUnstable":   `{{.Name}} is still unstable.{{template "details" .}}`,
+		"atLeastUnstable": `{{.Name}} is at least unstable now.{{template "details" .}}`,
+		"stillFailing":    `{{.Name}} still failing.{{template "details" .}}`,
+		"reminder":        `{{.Name}} still failing after {{.Duration}}.`,
+		"escalation":      `{{.Name}} has been failing for {{.Duration}} without being claimed.`,
+		"unreachable":     `{{.Instance}} unreachable since {{.Since}}.`,
+		"backOnline":      `{{.Instance}} is back online.`,
+		"stale":           `{{.Duration}} old`,
+		"quietHours":      `{{.Count}} notifications during quiet hours:`,
+		"groupSUCCESS":    `{{.Count}} {{if eq .Count 1}}job{{else}}jobs{{end}} succeeded on {{.Instance}}: {{.Jobs}}`,
+		"groupUNSTABLE":   `{{.Count}} {{if eq .Count 1}}job{{else}}jobs{{end}} became unstable on {{.Instance}}: {{.Jobs}}`,
+		"groupFAILURE":    `{{.Count}} {{if eq .Count 1}}job{{else}}jobs{{end}} failed on {{.Instance}}: {{.Jobs}}`,
+		"groupABORTED":    `{{.Count}} {{if eq .Count 1}}job{{else}}jobs{{end}} aborted on {{.Instance}}: {{.Jobs}}`,
+		"groupNOT_BUILT":  `{{.Count}} {{if eq .Count 1}}job{{else}}jobs{{end}} not built on {{.Instance}}: {{.Jobs}}`,
+		"group":           `{{.Count}} {{if eq .Count 1}}job{{else}}jobs{{end}} finished on {{.Instance}}: {{.Jobs}}`,
+		"mailSubject":     `{{.Count}} job notifications`,
+		"minutes":         `{{.Count}} minutes`,
+		"hours":           `{{.Count}} hours`,
+		"days":            `{{.Count}} days`,
+	},
+	"de": {
+		"details": `{{if .Tests.Failed}} {{.Tests.Failed}} von {{.Tests.Total}} Tests fehlgeschlagen.{{end}}` +
+			`{{if .Culprits}} Änderungen von {{join .Culprits ", "}}.{{end}}`,
+		"stillSuccessful": `{{.Name}} ist weiterhin erfolgreich.`,
+		"unstable":        `{{.Name}} ist instabil geworden.{{template "details" .}}`,
+		"failed":          `{{.Name}} ist fehlgeschlagen.{{template "details" .}}`,
+		"successfulAgain": `{{.Name}} ist wieder erfolgreich.`,
+		"stillUnstable":   `{{.Name}} ist weiterhin instabil.{{template "details" .}}`,
+		"atLeastUnstable": `{{.Name}} ist jetzt zumindest nur noch instabil.{{template "details" .}}`,
+		"stillFailing":    `{{.Name}} schlägt weiterhin fehl.{{template "details" .}}`,
+		"reminder":        `{{.Name}} schlägt seit {{.Duration}} fehl.`,
+		"escalation":      `{{.Name}} schlägt seit {{.Duration}} fehl, ohne dass es jemand übernommen hat.`,
+		"unreachable":     `{{.Instance}} ist seit {{.Since}} nicht erreichbar.`,
+		"backOnline":      `{{.Instance}} ist wieder erreichbar.`,
+		"stale":           `seit {{.Duration}}`,
+		"quietHours":      `{{.Count}} Benachrichtigungen während der Ruhezeit:`,
+		"groupSUCCESS":    `{{.Count}} {{if eq .Count 1}}Job{{else}}Jobs{{end}} auf {{.Instance}} erfolgreich: {{.Jobs}}`,
+		"groupUNSTABLE":   `{{.Count}} {{if eq .Count 1}}Job{{else}}Jobs{{end}} auf {{.Instance}} instabil: {{.Jobs}}`,
+		"groupFAILURE":    `{{.Count}} {{if eq .Count 1}}Job{{else}}Jobs{{end}} auf {{.Instance}} fehlgeschlagen: {{.Jobs}}`,
+		"groupABORTED":    `{{.Count}} {{if eq .Count 1}}Job{{else}}Jobs{{end}} auf {{.Instance}} abgebrochen: {{.Jobs}}`,
+		"groupNOT_BUILT":  `{{.Count}} {{if eq .Count 1}}Job{{else}}Jobs{{end}} auf {{.Instance}} nicht gebaut: {{.Jobs}}`,
+		"group":           `{{.Count}} {{if eq .Count 1}}Job{{else}}Jobs{{end}} auf {{.Instance}} beendet: {{.Jobs}}`,
+		"mailSubject":     `{{.Count}} Job-Benachrichtigungen`,
+		"minutes":         `{{.Count}} Minuten`,
+		"hours":           `{{.Count}} Stunden`,
+		"days":            `{{.Count}} Tagen`,
+	},
+}
+
+type testCounts struct {
+	Failed  int
+	Skipped int
+	Total   int
+	Passed  int
+}
+
+// messageData is available to the message templates. Fields not relevant for a message
+// are left empty.
+type messageData struct {
+	Name           string
+	Job            *job
+	Build          build
+	Status         string
+	PreviousStatus string
+	Culprits       []string
+	Tests          testCounts
+	Duration       string
+	Count          int
+	Instance       string
+	Jobs           string
+	// Since is the time of day an instance became unreachable.
+	Since string
+}
+
+func newMessageData(j *job) messageData {
+	b := j.LastCompletedBuild
+	data := messageData{
+		Name:   j.Name,
+		Job:    j,
+		Build:  b,
+		Status: b.Result,
+		Tests: testCounts{
+			Failed:  b.FailCount,
+			Skipped: b.SkipCount,
+			Total:   b.TotalCount,
+			Passed:  b.TotalCount - b.FailCount - b.SkipCount,
+		},
+	}
+	if b.Culprits != "" {
+		data.Culprits = strings.Split(b.Culprits, "\n")
+	}
+	return data
+}
+
+// messageSet formats notification texts from the catalog of one language.
+type messageSet struct {
+	language  string
+	templates *template.Template
+}
+
+// currentMessages holds the *messageSet of the settings. It is replaced by setupPipeline
+// while notifications are formatted on other goroutines.
+var currentMessages atomic.Value
+
+var defaultMessages = newMessageSet("en", nil)
+
+// notifyMessages returns the templates used for all notifications.
+func notifyMessages() *messageSet {
+	if s, ok := currentMessages.Load().(*messageSet); ok {
+		return s
+	}
+	return defaultMessages
+}
+
+// newMessageSet parses the English catalog, the catalog of the language and the
+// overrides, each one replacing the templates of the ones before.
+func newMessageSet(language string, overrides map[string]string) *messageSet {
+	if _, ok := messageCatalogs[language]; !ok {
+		language = "en"
+	}
+	funcs := template.FuncMap{"join": strings.Join}
+	templates := template.New("").Funcs(funcs)
+	for _, catalog := range []map[string]string{messageCatalogs["en"], messageCatalogs[language]} {
+		for id, text := range catalog {
+			template.Must(templates.New(id).Parse(text))
+		}
+	}
+	for id, text := range overrides {
+		// parse separately first, a failed parse would leave a broken template in the set
+		if _, err := template.New(id).Funcs(funcs).Parse(text); err != nil {
+			log.Println("messages: ignoring invalid template", id, err)
+			continue
+		}
+		templates.New(id).Parse(text)
+	}
+	return &messageSet{language: language, templates: templates}
+}
+
+func loadMessages() *messageSet {
+	settings := walk.App().Settings()
+	language, ok := settings.Get("Language")
+	if !ok || language == "" {
+		language = userLanguage()
+	}
+	var overrides map[string]string
+	overridesStr, ok := settings.Get("Messages")
+	if ok && overridesStr != "" {
+		err := json.Unmarshal([]byte(overridesStr), &overrides)
+		if err != nil {
+			log.Println("loadMessages:", err)
+		}
+	}
+	return newMessageSet(strings.ToLower(language), overrides)
+}
+
+// format executes the template with the given id. Errors are logged and the id returned.
+func (s *messageSet) format(id string, data messageData) string {
+	var b strings.Builder
+	err := s.templates.ExecuteTemplate(&b, id, data)
+	if err != nil {
+		log.Println("messages:", err)
+		return id
+	}
+	return b.String()
+}
+
+func (s *messageSet) duration(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return s.format("days", messageData{Count: int(d / (24 * time.Hour))})
+	case d >= 2*time.Hour:
+		return s.format("hours", messageData{Count: int(d / time.Hour)})
+	default:
+		return s.format("minutes", messageData{Count: int(d / time.Minute)})
+	}
+}
+
+var procGetUserDefaultLocaleName = syscall.NewLazyDLL("kernel32.dll").NewProc("GetUserDefaultLocaleName")
+
+// userLanguage returns the language part of the user's Windows locale, e.g. "de" for
+// "de-DE".
+func userLanguage() string {
+	buf := make([]uint16, 85) // LOCALE_NAME_MAX_LENGTH
+	ret, _, _ := procGetUserDefaultLocaleName.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if ret == 0 {
+		return "en"
+	}
+	locale := syscall.UTF16ToString(buf)
+	return strings.SplitN(locale, "-", 2)[0]
+}
@@ -5,11 +5,39 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"JenkinsCheck/adaptive"
 )
 
 type jobs struct {
 	Jobs []*job `json:"jobs"`
+	// Unreachable are the URLs that could not be queried.
+	Unreachable []string `json:"-"`
+	// Skipped are the URLs not queried at this poll.
+	Skipped []string `json:"-"`
+	err     error
+	// latency is the time until the response headers arrived, retryAfter the delay asked
+	// for by a 429 or 503 response.
+	latency    time.Duration
+	retryAfter time.Duration
+	// observed are the polls of the queried instances by URL.
+	observed map[string]*adaptive.Observation
+}
+
+// failedJobs returns a row describing why the jobs of url could not be queried.
+func failedJobs(url string, err error) jobs {
+	return jobs{
+		Jobs: []*job{{
+			Name:    fmt.Sprintf("%c%s (%s)", 9, err, url),
+			Jenkins: url,
+		}},
+		Unreachable: []string{url},
+		err:         err,
+	}
 }
 
 type job struct {
@@ -20,7 +48,76 @@ type job struct {
 	LastBuild          build  `json:"lastBuild,omitempty"`
 	LastCompletedBuild build  `json:"lastCompletedBuild,omitempty"`
 	Class              string `json:"_class,omitempty"`
+	InQueue            bool   `json:"inQueue"`
 	Jenkins            string `json:"-"`
+	Muted              bool   `json:"-"`
+	// Dynamic is set for jobs monitored because they match a job rule.
+	Dynamic bool   `json:"-"`
+	Claim   *claim `json:"-"`
+	// Groups are the names of the groups of the job.
+	Groups     []string `json:"-"`
+	GroupMuted bool     `json:"-"`
+	// Collapsed is set for the row standing for the jobs of a collapsed group.
+	Collapsed bool `json:"-"`
+	// Stale is the time the shown state was polled if the instance has not answered since.
+	Stale time.Time `json:"-"`
+	// polled is the time the job was last updated.
+	polled time.Time
+}
+
+// updated returns the time the shown state was polled.
+func (j *job) updated() time.Time {
+	if !j.Stale.IsZero() {
+		return j.Stale
+	}
+	return j.polled
+}
+
+// StaleText describes the age of a stale state for the table.
+func (j *job) StaleText() string {
+	if j.Stale.IsZero() {
+		return ""
+	}
+	return notifyMessages().format("stale", messageData{Duration: notifyMessages().duration(time.Since(j.Stale))})
+}
+
+// due reports whether the job is to be updated when its instance is polled. Jobs of groups
+// with an interval are updated at that interval, the others at every poll.
+func (j *job) due(groupInterval int, now time.Time) bool {
+	if groupInterval <= 0 {
+		return true
+	}
+	// the ticker may fire slightly early
+	return !now.Before(j.polled.Add(time.Duration(groupInterval)*time.Second - time.Second))
+}
+
+// Group returns the group the job is shown in, empty if it has none.
+func (j *job) Group() string {
+	if len(j.Groups) == 0 {
+		return ""
+	}
+	return j.Groups[0]
+}
+
+// GroupText lists the groups for the table.
+func (j *job) GroupText() string {
+	return strings.Join(j.Groups, ", ")
+}
+
+// Claimed reports whether somebody is taking care of the job.
+func (j *job) Claimed() bool {
+	return j.Claim != nil
+}
+
+// ClaimText describes the claim for the table.
+func (j *job) ClaimText() string {
+	if j.Claim == nil {
+		return ""
+	}
+	if j.Claim.Comment == "" {
+		return j.Claim.By
+	}
+	return j.Claim.By + ": " + j.Claim.Comment
 }
 
 type build struct {
@@ -28,13 +125,40 @@ type build struct {
 	Label     int       `json:"number"`
 	Result    string    `json:"result,omitempty"`
 	Timestamp time.Time `json:"timestamp,omitempty"`
+	// Claimable is set if the Claim plugin is active for the build.
+	Claimable   bool   `json:"-"`
+	ClaimedBy   string `json:"-"`
+	ClaimReason string `json:"-"`
+	// Culprits are the full names of the users who made changes, one per line. Names may
+	// contain commas.
+	Culprits   string `json:"-"`
+	FailCount  int    `json:"-"`
+	SkipCount  int    `json:"-"`
+	TotalCount int    `json:"-"`
 }
 
+const (
+	claimActionClass      = "hudson.plugins.claim.ClaimBuildAction"
+	testResultActionClass = "hudson.tasks.junit.TestResultAction"
+)
+
 func (b *build) UnmarshalJSON(s []byte) error {
 	type Alias build
 	alias := struct {
 		*Alias
 		Timestamp int64 `json:"timestamp"`
+		Actions   []struct {
+			Class      string `json:"_class"`
+			Claimed    bool   `json:"claimed"`
+			ClaimedBy  string `json:"claimedBy"`
+			Reason     string `json:"reason"`
+			FailCount  int    `json:"failCount"`
+			SkipCount  int    `json:"skipCount"`
+			TotalCount int    `json:"totalCount"`
+		} `json:"actions"`
+		Culprits []struct {
+			FullName string `json:"fullName"`
+		} `json:"culprits"`
 	}{}
 	err := json.Unmarshal(s, &alias)
 	if err != nil {
@@ -45,6 +169,25 @@ func (b *build) UnmarshalJSON(s []byte) error {
 		b.Label = alias.Label
 		b.Result = alias.Result
 		b.Timestamp = time.Unix(alias.Timestamp/1000, 0)
+		for _, action := range alias.Actions {
+			switch action.Class {
+			case claimActionClass:
+				b.Claimable = true
+				if action.Claimed {
+					b.ClaimedBy = action.ClaimedBy
+					b.ClaimReason = action.Reason
+				}
+			case testResultActionClass:
+				b.FailCount = action.FailCount
+				b.SkipCount = action.SkipCount
+				b.TotalCount = action.TotalCount
+			}
+		}
+		culprits := make([]string, len(alias.Culprits))
+		for i, culprit := range alias.Culprits {
+			culprits[i] = culprit.FullName
+		}
+		b.Culprits = strings.Join(culprits, "\n")
 	}
 	return nil
 }
@@ -53,39 +196,37 @@ func getJobsFromMultiple(urls []string) jobs {
 	var jobs jobs
 	for _, url := range urls {
 		j := getJobs(url)
+		if j.err != nil {
+			log.Println(url, j.err)
+		}
 		jobs.Jobs = append(jobs.Jobs, j.Jobs...)
+		jobs.Unreachable = append(jobs.Unreachable, j.Unreachable...)
 	}
 	return jobs
 }
 
 func getJobs(url string) jobs {
-	resp, err := http.Get(url + "/api/json?tree=jobs[_class,fullDisplayName,displayName,url,color," +
-		"lastBuild[number,timestamp,result,building],lastCompletedBuild[number,timestamp,result,building]]")
+	start := time.Now()
+	resp, err := clientFor(url).Get(url + "/api/json?tree=jobs[_class,fullDisplayName,displayName,url,color,inQueue," +
+		"lastBuild[number,timestamp,result,building]," +
+		"lastCompletedBuild[number,timestamp,result,building,culprits[fullName]," +
+		"actions[_class,claimed,claimedBy,reason,failCount,skipCount,totalCount]]]")
 	var jobs jobs
 	if resp != nil && resp.Body != nil {
 		defer resp.Body.Close()
 	}
 	if err != nil {
-		log.Println(url, "Request failed:", err)
-		items := make([]*job, 1)
-		items[0] = &job{
-			Name:    fmt.Sprintf("%cRequest failed: %s (%s)", 9, err, url),
-			Jenkins: url,
-		}
-		jobs.Jobs = items
-		return jobs
+		return failedJobs(url, fmt.Errorf("Request failed: %w", err))
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		log.Println(url, "Reponse was not OK:", resp.StatusCode)
-		items := make([]*job, 1)
-		items[0] = &job{
-			Name:    fmt.Sprintf("%cReponse was not OK: %d (%s)", 9, resp.StatusCode, url),
-			Jenkins: url,
+		jobs = failedJobs(url, fmt.Errorf("Reponse was not OK: %d", resp.StatusCode))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			jobs.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
 		}
-		jobs.Jobs = items
 		return jobs
 	}
+	jobs.latency = time.Since(start)
 
 	time.AfterFunc(10*time.Second, func() {
 		resp.Body.Close()
@@ -94,13 +235,7 @@ func getJobs(url string) jobs {
 	decoder := json.NewDecoder(resp.Body)
 	err = decoder.Decode(&jobs)
 	if err != nil {
-		log.Println(url, err)
-		items := make([]*job, 1)
-		items[0] = &job{
-			Name:    fmt.Sprintf("%cResponse could not be decoded: %s (%s)", 9, err, url),
-			Jenkins: url,
-		}
-		jobs.Jobs = items
+		jobs = failedJobs(url, fmt.Errorf("Response could not be decoded: %w", err))
 	}
 
 	var deleteIdx []int
@@ -120,6 +255,17 @@ func getJobs(url string) jobs {
 	return jobs
 }
 
+// parseRetryAfter returns the delay of a Retry-After header given in seconds or as date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
 func deleteFromJobsArray(input []*job, indexes ...int) []*job {
 	var output []*job
 	lastIdx := 0
@@ -130,3 +276,89 @@ func deleteFromJobsArray(input []*job, indexes ...int) []*job {
 	output = append(output, input[lastIdx:]...)
 	return output
 }
+
+// statusRank orders build results from best to worst.
+var statusRank = map[string]int{
+	"SUCCESS":   1,
+	"NOT_BUILT": 2,
+	"ABORTED":   2,
+	"UNSTABLE":  3,
+	"FAILURE":   4,
+}
+
+// aggregateState summarizes all monitored jobs.
+type aggregateState struct {
+	// Worst is the worst last completed build result.
+	Worst    string
+	Total    int
+	Failing  []string
+	Unstable []string
+	Building int
+	// Unreachable are the Jenkins URLs that could not be queried.
+	Unreachable []string
+}
+
+func newAggregateState(items []*job, unreachable []string) aggregateState {
+	state := aggregateState{Total: len(items), Unreachable: unreachable}
+	for _, j := range items {
+		result := j.LastCompletedBuild.Result
+		if statusRank[result] > statusRank[state.Worst] {
+			state.Worst = result
+		}
+		switch result {
+		case "FAILURE":
+			state.Failing = append(state.Failing, j.Name)
+		case "UNSTABLE":
+			state.Unstable = append(state.Unstable, j.Name)
+		}
+		if j.LastBuild.Building {
+			state.Building++
+		}
+	}
+	return state
+}
+
+// level condenses the state to "failure", "unstable", "success" or "unknown". Aborted and
+// not built jobs are no problem and count as successful. Unknown covers jobs without results
+// as well as unreachable instances that might hide failures.
+func (a *aggregateState) level() string {
+	switch a.Worst {
+	case "FAILURE":
+		return "failure"
+	case "UNSTABLE":
+		return "unstable"
+	case "SUCCESS", "ABORTED", "NOT_BUILT":
+		if len(a.Unreachable) == 0 {
+			return "success"
+		}
+		return "unknown"
+	default:
+		return "unknown"
+	}
+}
+
+func (a *aggregateState) equal(b *aggregateState) bool {
+	return a.Worst == b.Worst && a.Total == b.Total && a.Building == b.Building &&
+		equalStrings(a.Failing, b.Failing) && equalStrings(a.Unstable, b.Unstable) &&
+		equalStrings(a.Unreachable, b.Unreachable)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// instanceName returns the host of a Jenkins URL for display.
+func instanceName(jenkinsURL string) string {
+	if u, err := url.Parse(jenkinsURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return jenkinsURL
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"JenkinsCheck/mqttclient"
+
+	"github.com/lxn/walk"
+)
+
+// mqttSettings is stored as JSON in the "MQTT" setting.
+type mqttSettings struct {
+	// Broker is the address of the broker, e.g. tcp://localhost:1883 or ssl://broker:8883.
+	Broker   string
+	ClientID string
+	Username string
+	// Password should be left empty in favor of Credential, the id of a stored credential
+	// with the user name and the password as token.
+	Password   string
+	Credential string
+	QoS        byte
+	// Prefix of all published topics. Defaults to "jenkinscheck".
+	Prefix string
+}
+
+func getMQTTSettings() mqttSettings {
+	settings := walk.App().Settings()
+	var mqtt mqttSettings
+	mqttStr, ok := settings.Get("MQTT")
+	if !ok || mqttStr == "" {
+		return mqtt
+	}
+	err := json.Unmarshal([]byte(mqttStr), &mqtt)
+	if err != nil {
+		log.Println("getMQTTSettings:", err)
+	}
+	mqtt.Username, mqtt.Password = notifierLogin("mqtt", mqtt.Credential, mqtt.Username, mqtt.Password)
+	return mqtt
+}
+
+type mqttJobState struct {
+	Name      string    `json:"name"`
+	Instance  string    `json:"instance"`
+	URL       string    `json:"url"`
+	Status    string    `json:"status"`
+	Building  bool      `json:"building"`
+	Build     int       `json:"build"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type mqttAggregateState struct {
+	Status      string   `json:"status"`
+	Total       int      `json:"total"`
+	Failing     int      `json:"failing"`
+	Unstable    int      `json:"unstable"`
+	Building    int      `json:"building"`
+	Unreachable []string `json:"unreachable"`
+}
+
+const mqttKeepAlive = 60 * time.Second
+
+// mqttPublisher publishes the state of every changed job as a retained message and keeps an
+// aggregate status topic up to date. Messages that could not be delivered are retried with
+// their latest payload.
+type mqttPublisher struct {
+	settings mqttSettings
+	client   *mqttclient.Client
+	queue    chan map[string][]byte
+	done     chan struct{}
+}
+
+// newMQTTPublisher returns nil if no broker is configured.
+func newMQTTPublisher(settings mqttSettings) *mqttPublisher {
+	if settings.Broker == "" {
+		return nil
+	}
+	broker, err := url.Parse(settings.Broker)
+	if err != nil || broker.Host == "" {
+		log.Println("mqtt: invalid broker address", settings.Broker, err)
+		return nil
+	}
+	if settings.QoS > 2 {
+		log.Println("mqtt: invalid QoS", settings.QoS, "using 0")
+		settings.QoS = 0
+	}
+	if settings.Prefix == "" {
+		settings.Prefix = "jenkinscheck"
+	}
+	settings.Prefix = strings.TrimSuffix(settings.Prefix, "/")
+	if settings.ClientID == "" {
+		host, _ := os.Hostname()
+		settings.ClientID = "jenkinscheck-" + host
+	}
+	p := &mqttPublisher{
+		settings: settings,
+		client: mqttclient.New(mqttclient.Options{
+			Broker:    broker,
+			ClientID:  settings.ClientID,
+			Username:  settings.Username,
+			Password:  settings.Password,
+			QoS:       settings.QoS,
+			KeepAlive: mqttKeepAlive,
+		}),
+		queue: make(chan map[string][]byte, 10),
+		done:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *mqttPublisher) JobsChanged(changed []*job, state aggregateState) {
+	messages := make(map[string][]byte, len(changed)+1)
+	for _, j := range changed {
+		payload, err := json.Marshal(mqttJobState{
+			Name:      j.Name,
+			Instance:  j.Jenkins,
+			URL:       j.URL,
+			Status:    j.LastCompletedBuild.Result,
+			Building:  j.LastBuild.Building,
+			Build:     j.LastCompletedBuild.Label,
+			Timestamp: j.LastCompletedBuild.Timestamp,
+		})
+		if err != nil {
+			log.Println("mqtt:", err)
+			continue
+		}
+		messages[p.jobTopic(j)] = payload
+	}
+	payload, _ := json.Marshal(mqttAggregateState{
+		Status:      state.Worst,
+		Total:       state.Total,
+		Failing:     len(state.Failing),
+		Unstable:    len(state.Unstable),
+		Building:    state.Building,
+		Unreachable: state.Unreachable,
+	})
+	messages[p.settings.Prefix+"/status"] = payload
+
+	select {
+	case p.queue <- messages:
+	case <-p.done:
+	default:
+		log.Println("mqtt: queue is full, dropping", len(messages), "messages")
+	}
+}
+
+// jobTopic derives the topic <prefix>/<instance>/<job path> from the job and instance URLs.
+func (p *mqttPublisher) jobTopic(j *job) string {
+	instance := instanceName(j.Jenkins)
+	path := mqttTopicLevel(j.Name)
+	if u, err := url.Parse(j.URL); err == nil && strings.Contains(u.Path, "/job/") {
+		var segments []string
+		for _, segment := range strings.Split(u.Path, "/job/")[1:] {
+			segment = strings.Trim(segment, "/")
+			if unescaped, err := url.PathUnescape(segment); err == nil {
+				segment = unescaped
+			}
+			segments = append(segments, mqttTopicLevel(segment))
+		}
+		path = strings.Join(segments, "/")
+	}
+	return p.settings.Prefix + "/" + mqttTopicLevel(instance) + "/" + path
+}
+
+func mqttTopicLevel(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", ":", "_").Replace(s)
+}
+
+func (p *mqttPublisher) run() {
+	defer handlePanic()
+	ticker := time.NewTicker(mqttKeepAlive / 2)
+	defer ticker.Stop()
+	pending := make(map[string][]byte)
+	for {
+		select {
+		case messages := <-p.queue:
+			for topic, payload := range messages {
+				pending[topic] = payload
+			}
+		case <-ticker.C:
+			if len(pending) == 0 && p.client.Connected() {
+				if err := p.client.Ping(); err != nil {
+					log.Println("mqtt: ping failed:", err)
+					p.client.Disconnect()
+				}
+				continue
+			}
+		case <-p.done:
+			p.client.Disconnect()
+			return
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		for topic, payload := range pending {
+			if err := p.client.Publish(topic, payload); err != nil {
+				log.Println("mqtt: publishing", topic, "failed:", err)
+				p.client.Disconnect()
+				break
+			}
+			delete(pending, topic)
+		}
+	}
+}
+
+// Close disconnects from the broker. Messages not published yet are dropped.
+func (p *mqttPublisher) Close() {
+	close(p.done)
+}
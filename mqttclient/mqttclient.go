@@ -0,0 +1,255 @@
+// Package mqttclient is a minimal MQTT 3.1.1 client that publishes retained messages with
+// QoS 0, 1 or 2. It connects on the first publish and again after a failure.
+package mqttclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Options configure a Client.
+type Options struct {
+	// Broker is the address of the broker, e.g. tcp://localhost:1883 or ssl://broker:8883.
+	Broker   *url.URL
+	ClientID string
+	Username string
+	Password string
+	QoS      byte
+	// KeepAlive is the longest time the client stays silent. The caller pings in between.
+	KeepAlive time.Duration
+	// TLS configures ssl brokers. Nil verifies the certificate of the broker with the system
+	// roots.
+	TLS *tls.Config
+}
+
+// Client publishes to one broker. It is not safe for concurrent use.
+type Client struct {
+	options  Options
+	conn     net.Conn
+	reader   *bufio.Reader
+	packetID uint16
+}
+
+// New returns a disconnected Client.
+func New(options Options) *Client {
+	return &Client{options: options}
+}
+
+// Connected reports whether the client has a connection to the broker.
+func (c *Client) Connected() bool {
+	return c.conn != nil
+}
+
+func (c *Client) connect() error {
+	broker := c.options.Broker
+	address := broker.Host
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch broker.Scheme {
+	case "ssl", "tls", "mqtts":
+		if broker.Port() == "" {
+			address = net.JoinHostPort(address, "8883")
+		}
+		tlsConfig := c.options.TLS
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: broker.Hostname()}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	default:
+		if broker.Port() == "" {
+			address = net.JoinHostPort(address, "1883")
+		}
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendString(payload, c.options.ClientID)
+	if c.options.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, c.options.Username)
+		if c.options.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, c.options.Password)
+		}
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = appendUint16(body, uint16(c.options.KeepAlive/time.Second))
+	body = append(body, payload...)
+	if err = c.writePacket(0x10, body); err != nil {
+		c.Disconnect()
+		return err
+	}
+	packetType, resp, err := c.readPacket()
+	if err != nil {
+		c.Disconnect()
+		return err
+	}
+	if packetType != 0x20 || len(resp) < 2 {
+		c.Disconnect()
+		return fmt.Errorf("unexpected packet %#x instead of CONNACK", packetType)
+	}
+	if resp[1] != 0 {
+		c.Disconnect()
+		return fmt.Errorf("connection refused with return code %d", resp[1])
+	}
+	return nil
+}
+
+// Disconnect ends the connection to the broker, if any.
+func (c *Client) Disconnect() {
+	if c.conn == nil {
+		return
+	}
+	c.writePacket(0xE0, nil)
+	c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+}
+
+// Publish sends a retained message and waits for the acknowledgements of its QoS. It
+// connects first if needed. After an error the caller disconnects to start over.
+func (c *Client) Publish(topic string, payload []byte) error {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+	qos := c.options.QoS
+	body := appendString(nil, topic)
+	var id uint16
+	if qos > 0 {
+		c.packetID++
+		if c.packetID == 0 {
+			c.packetID = 1
+		}
+		id = c.packetID
+		body = appendUint16(body, id)
+	}
+	body = append(body, payload...)
+	// retained, no dup
+	if err := c.writePacket(0x30|qos<<1|0x01, body); err != nil {
+		return err
+	}
+	switch qos {
+	case 1:
+		return c.expect(0x40, id)
+	case 2:
+		if err := c.expect(0x50, id); err != nil {
+			return err
+		}
+		if err := c.writePacket(0x62, appendUint16(nil, id)); err != nil {
+			return err
+		}
+		return c.expect(0x70, id)
+	}
+	return nil
+}
+
+// Ping keeps the connection alive.
+func (c *Client) Ping() error {
+	if c.conn == nil {
+		return errors.New("not connected")
+	}
+	if err := c.writePacket(0xC0, nil); err != nil {
+		return err
+	}
+	packetType, _, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if packetType != 0xD0 {
+		return fmt.Errorf("unexpected packet %#x instead of PINGRESP", packetType)
+	}
+	return nil
+}
+
+// expect reads the next packet and checks its type and packet identifier.
+func (c *Client) expect(packetType byte, id uint16) error {
+	gotType, body, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if gotType&0xF0 != packetType || len(body) < 2 || binary.BigEndian.Uint16(body) != id {
+		return fmt.Errorf("unexpected packet %#x instead of %#x for id %d", gotType, packetType, id)
+	}
+	return nil
+}
+
+func (c *Client) writePacket(header byte, body []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return writePacket(c.conn, header, body)
+}
+
+func (c *Client) readPacket() (byte, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	return readPacket(c.reader)
+}
+
+func writePacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	_, err := w.Write(packet)
+	return err
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 0
+	multiplier := 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7F) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
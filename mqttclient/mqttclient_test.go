@@ -0,0 +1,278 @@
+package mqttclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// message is a PUBLISH accepted by the broker.
+type message struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+// broker is a local MQTT broker accepting one client at a time. It acknowledges publishes
+// with the packets of their QoS and records them.
+type broker struct {
+	listener net.Listener
+	// user and password are required if user is set.
+	user     string
+	password string
+
+	mutex    sync.Mutex
+	messages []message
+	clients  []string
+	pings    int
+	conns    []net.Conn
+}
+
+func startBroker(t *testing.T, user, password string) *broker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &broker{listener: listener, user: user, password: password}
+	t.Cleanup(func() {
+		listener.Close()
+		b.dropClients()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.mutex.Lock()
+			b.conns = append(b.conns, conn)
+			b.mutex.Unlock()
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *broker) options(qos byte) Options {
+	return Options{
+		Broker:    &url.URL{Scheme: "tcp", Host: b.listener.Addr().String()},
+		ClientID:  "JenkinsCheck-test",
+		Username:  b.user,
+		Password:  b.password,
+		QoS:       qos,
+		KeepAlive: time.Minute,
+	}
+}
+
+func (b *broker) received() []message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]message(nil), b.messages...)
+}
+
+// dropClients closes the connections like a restarting broker.
+func (b *broker) dropClients() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.conns = nil
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	header, body, err := readPacket(r)
+	if err != nil || header != 0x10 {
+		return
+	}
+	clientID, user, password, ok := parseConnect(body)
+	if !ok {
+		return
+	}
+	if b.user != "" && (user != b.user || password != b.password) {
+		writePacket(conn, 0x20, []byte{0, 5})
+		return
+	}
+	b.mutex.Lock()
+	b.clients = append(b.clients, clientID)
+	b.mutex.Unlock()
+	writePacket(conn, 0x20, []byte{0, 0})
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch header & 0xF0 {
+		case 0x30:
+			qos := header >> 1 & 0x03
+			topicLength := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+topicLength])
+			rest := body[2+topicLength:]
+			var id []byte
+			if qos > 0 {
+				id, rest = rest[:2], rest[2:]
+			}
+			b.mutex.Lock()
+			b.messages = append(b.messages, message{topic, string(rest), qos, header&0x01 != 0})
+			b.mutex.Unlock()
+			switch qos {
+			case 1:
+				writePacket(conn, 0x40, id)
+			case 2:
+				writePacket(conn, 0x50, id)
+			}
+		case 0x60:
+			if header != 0x62 {
+				return
+			}
+			writePacket(conn, 0x70, body)
+		case 0xC0:
+			b.mutex.Lock()
+			b.pings++
+			b.mutex.Unlock()
+			writePacket(conn, 0xD0, nil)
+		case 0xE0:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// parseConnect returns the client id and the credentials of a CONNECT packet.
+func parseConnect(body []byte) (clientID, user, password string, ok bool) {
+	next := func() string {
+		if len(body) < 2 {
+			ok = false
+			return ""
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			ok = false
+			return ""
+		}
+		s := string(body[2 : 2+n])
+		body = body[2+n:]
+		return s
+	}
+	ok = true
+	if next() != "MQTT" || len(body) < 4 || body[0] != 4 {
+		return "", "", "", false
+	}
+	flags := body[1]
+	body = body[4:]
+	clientID = next()
+	if flags&0x80 != 0 {
+		user = next()
+	}
+	if flags&0x40 != 0 {
+		password = next()
+	}
+	return clientID, user, password, ok
+}
+
+func TestPublish(t *testing.T) {
+	for _, qos := range []byte{0, 1, 2} {
+		b := startBroker(t, "", "")
+		c := New(b.options(qos))
+		if c.Connected() {
+			t.Error("new client is connected")
+		}
+		for _, payload := range []string{`{"status":"FAILURE"}`, `{"status":"SUCCESS"}`} {
+			if err := c.Publish("jenkins/jobs/build", []byte(payload)); err != nil {
+				t.Fatalf("QoS %d: %v", qos, err)
+			}
+		}
+		if !c.Connected() {
+			t.Errorf("QoS %d: not connected after publishing", qos)
+		}
+		if err := c.Ping(); err != nil {
+			t.Errorf("QoS %d: Ping = %v", qos, err)
+		}
+		c.Disconnect()
+		if c.Connected() {
+			t.Errorf("QoS %d: connected after Disconnect", qos)
+		}
+		want := []message{
+			{"jenkins/jobs/build", `{"status":"FAILURE"}`, qos, true},
+			{"jenkins/jobs/build", `{"status":"SUCCESS"}`, qos, true},
+		}
+		got := b.received()
+		if len(got) != len(want) {
+			t.Fatalf("QoS %d: received %+v, want %+v", qos, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("QoS %d: message %d = %+v, want %+v", qos, i, got[i], want[i])
+			}
+		}
+		b.mutex.Lock()
+		if len(b.clients) != 1 || b.clients[0] != "JenkinsCheck-test" || b.pings != 1 {
+			t.Errorf("QoS %d: clients %v, pings %d", qos, b.clients, b.pings)
+		}
+		b.mutex.Unlock()
+	}
+}
+
+func TestPublishWithCredentials(t *testing.T) {
+	b := startBroker(t, "jc", "hunter2")
+	c := New(b.options(1))
+	if err := c.Publish("jenkins/state", []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	c.Disconnect()
+
+	options := b.options(1)
+	options.Password = "wrong"
+	c = New(options)
+	err := c.Publish("jenkins/state", []byte("refused"))
+	if err == nil || !strings.Contains(err.Error(), "return code 5") {
+		t.Errorf("Publish with a wrong password = %v, want return code 5", err)
+	}
+	if c.Connected() {
+		t.Error("connected after a refused connection")
+	}
+	if got := b.received(); len(got) != 1 || got[0].payload != "ok" {
+		t.Errorf("received %+v, want only the authenticated message", got)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	b := startBroker(t, "", "")
+	c := New(b.options(1))
+	if err := c.Publish("jenkins/state", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	b.dropClients()
+	if err := c.Publish("jenkins/state", []byte("lost")); err == nil {
+		t.Fatal("Publish succeeded on a closed connection")
+	}
+	// the caller disconnects after an error, the next publish connects again
+	c.Disconnect()
+	if err := c.Publish("jenkins/state", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	c.Disconnect()
+	got := b.received()
+	if len(got) != 2 || got[0].payload != "1" || got[1].payload != "2" {
+		t.Errorf("received %+v, want 1 and 2", got)
+	}
+	if err := c.Ping(); err == nil {
+		t.Error("Ping succeeded while disconnected")
+	}
+
+	b.listener.Close()
+	if err := c.Publish("jenkins/state", []byte("3")); err == nil {
+		t.Error("Publish succeeded without broker")
+	}
+}
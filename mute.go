@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+// jobMute suppresses notifications of a job. Without Until and UntilChange it lasts until
+// it is turned off.
+type jobMute struct {
+	Name     string
+	Instance string
+	Until    time.Time `json:",omitempty"`
+	// UntilChange lifts the mute as soon as the job's result differs from Status.
+	UntilChange bool   `json:",omitempty"`
+	Status      string `json:",omitempty"`
+}
+
+func (mute *jobMute) expired(now time.Time) bool {
+	return !mute.Until.IsZero() && !now.Before(mute.Until)
+}
+
+// muteList holds the mutes of all jobs and persists them in the "Mutes" setting.
+type muteList struct {
+	mutex sync.Mutex
+	mutes []*jobMute
+}
+
+func loadMutes() *muteList {
+	list := new(muteList)
+	settings := walk.App().Settings()
+	mutesStr, ok := settings.Get("Mutes")
+	if !ok || mutesStr == "" {
+		return list
+	}
+	err := json.Unmarshal([]byte(mutesStr), &list.mutes)
+	if err != nil {
+		log.Println("loadMutes:", err)
+	}
+	list.expire(time.Now())
+	return list
+}
+
+// save writes the mutes to the settings. The caller must hold the mutex.
+func (l *muteList) save() {
+	settings := walk.App().Settings()
+	if len(l.mutes) == 0 {
+		settings.Remove("Mutes")
+		return
+	}
+	mutesJSON, _ := json.Marshal(l.mutes)
+	settings.Put("Mutes", string(mutesJSON))
+}
+
+func (l *muteList) find(j *job) int {
+	for i, mute := range l.mutes {
+		if mute.Name == j.Name && mute.Instance == j.Jenkins {
+			return i
+		}
+	}
+	return -1
+}
+
+// mute replaces any existing mute of the job. A zero until mutes without time limit.
+func (l *muteList) mute(j *job, until time.Time, untilChange bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	mute := &jobMute{
+		Name:        j.Name,
+		Instance:    j.Jenkins,
+		Until:       until,
+		UntilChange: untilChange,
+	}
+	if untilChange {
+		mute.Status = j.LastCompletedBuild.Result
+	}
+	if idx := l.find(j); idx >= 0 {
+		l.mutes[idx] = mute
+	} else {
+		l.mutes = append(l.mutes, mute)
+	}
+	l.save()
+	log.Println(j.Name, "muted")
+}
+
+func (l *muteList) unmute(j *job) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if idx := l.find(j); idx >= 0 {
+		l.mutes = append(l.mutes[:idx], l.mutes[idx+1:]...)
+		l.save()
+		log.Println(j.Name, "unmuted")
+	}
+}
+
+// isMuted reports whether notifications of the job are suppressed at the given time. Mutes
+// that are expired or whose job changed its status are removed.
+func (l *muteList) isMuted(j *job, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	idx := l.find(j)
+	if idx < 0 {
+		return false
+	}
+	mute := l.mutes[idx]
+	if mute.expired(now) ||
+		mute.UntilChange && j.LastCompletedBuild.Result != "" && j.LastCompletedBuild.Result != mute.Status {
+		l.mutes = append(l.mutes[:idx], l.mutes[idx+1:]...)
+		l.save()
+		log.Println(j.Name, "mute lifted")
+		return false
+	}
+	return true
+}
+
+func (l *muteList) expire(now time.Time) {
+	var mutes []*jobMute
+	for _, mute := range l.mutes {
+		if !mute.expired(now) {
+			mutes = append(mutes, mute)
+		}
+	}
+	if len(mutes) != len(l.mutes) {
+		l.mutes = mutes
+		l.save()
+	}
+}
+
+func (m *jobModel) muteJob(idx int, until time.Time, untilChange bool) {
+	item := m.items[idx]
+	m.mutes.mute(item, until, untilChange)
+	item.Muted = true
+	m.PublishRowChanged(idx)
+	if err := walk.App().Settings().Save(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *jobModel) unmuteJob(idx int) {
+	item := m.items[idx]
+	m.mutes.unmute(item)
+	item.Muted = false
+	m.PublishRowChanged(idx)
+	if err := walk.App().Settings().Save(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+type eventSeverity int
+
+const (
+	severityInfo eventSeverity = iota
+	severityWarning
+	severityError
+)
+
+func (s eventSeverity) String() string {
+	switch s {
+	case severityWarning:
+		return "warning"
+	case severityError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// jobEvent is a status transition of a monitored job between two completed builds. A group
+// event has no Job and combines several events under one summary message. An instance event
+// reports an instance as unreachable or online again, its Job stands for the instance.
+type jobEvent struct {
+	Time      time.Time
+	Job       *job
+	OldStatus string
+	NewStatus string
+	Severity  eventSeverity
+	Message   string
+	Events    []*jobEvent
+	Instance  bool
+}
+
+// newGroupEvent combines events into one notification with the worst of their severities.
+func newGroupEvent(summary string, events []*jobEvent) *jobEvent {
+	group := &jobEvent{
+		Time:    time.Now(),
+		Message: summary,
+	}
+	for _, ev := range events {
+		group.Events = append(group.Events, ev.members()...)
+		if ev.Severity > group.Severity {
+			group.Severity = ev.Severity
+		}
+	}
+	return group
+}
+
+// members returns the single job events an event consists of.
+func (ev *jobEvent) members() []*jobEvent {
+	if ev.Job == nil {
+		return ev.Events
+	}
+	return []*jobEvent{ev}
+}
+
+// eventData is the serialized form of a jobEvent handed to external programs.
+type eventData struct {
+	Time           time.Time `json:"time"`
+	Job            string    `json:"job"`
+	Instance       string    `json:"instance"`
+	URL            string    `json:"url"`
+	Build          int       `json:"build"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previousStatus"`
+	Severity       string    `json:"severity"`
+	Message        string    `json:"message"`
+}
+
+func (ev *jobEvent) data() eventData {
+	return eventData{
+		Time:           ev.Time,
+		Job:            ev.Job.Name,
+		Instance:       ev.Job.Jenkins,
+		URL:            ev.Job.URL,
+		Build:          ev.Job.LastCompletedBuild.Label,
+		Status:         ev.NewStatus,
+		PreviousStatus: ev.OldStatus,
+		Severity:       ev.Severity.String(),
+		Message:        ev.Message,
+	}
+}
+
+// notifier is a sink for job events. Implementations must not block the polling loop. done
+// is called exactly once with the result of the delivery, by sinks running in the background
+// after the event was delivered.
+type notifier interface {
+	Name() string
+	Notify(ev *jobEvent, done func(err error))
+}
+
+// joinDone returns a function to be called n times that calls done once with the first error.
+func joinDone(n int, done func(err error)) func(err error) {
+	if n == 0 {
+		done(nil)
+		return func(error) {}
+	}
+	var mutex sync.Mutex
+	var first error
+	return func(err error) {
+		mutex.Lock()
+		n--
+		if first == nil {
+			first = err
+		}
+		last := n == 0
+		mutex.Unlock()
+		if last {
+			done(first)
+		}
+	}
+}
+
+// closer is implemented by sinks running in the background. Close must not block.
+type closer interface {
+	Close()
+}
+
+var (
+	errClosed    = errors.New("closed after the settings changed")
+	errQueueFull = errors.New("queue is full")
+)
+
+// queuedEvent is an event waiting for a sink running in the background.
+type queuedEvent struct {
+	ev   *jobEvent
+	done func(err error)
+}
+
+// queueGuard keeps a sink running in the background from queueing events after it was
+// closed, so its worker can report the events left in the queue as not delivered.
+type queueGuard struct {
+	mutex  sync.Mutex
+	closed bool
+}
+
+// enqueue calls send unless the sink is closed. send reports whether the queue had room.
+func (g *queueGuard) enqueue(send func() bool) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.closed {
+		return errClosed
+	}
+	if !send() {
+		return errQueueFull
+	}
+	return nil
+}
+
+// close refuses further events and calls stop to stop the worker.
+func (g *queueGuard) close(stop func()) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if !g.closed {
+		g.closed = true
+		stop()
+	}
+}
+
+// drain reports the events left in a closed queue as not delivered.
+func drain(queue chan queuedEvent) {
+	for {
+		select {
+		case queued := <-queue:
+			queued.done(errClosed)
+		default:
+			return
+		}
+	}
+}
+
+// stateObserver is informed after updateJobs applied changes to the monitored jobs or their
+// aggregate state changed.
+type stateObserver interface {
+	JobsChanged(changed []*job, state aggregateState)
+}
+
+// transitionMessages maps the previous and the new result to the id of the message to show.
+var transitionMessages = map[[2]string]string{
+	{"SUCCESS", "SUCCESS"}:   "stillSuccessful",
+	{"SUCCESS", "UNSTABLE"}:  "unstable",
+	{"SUCCESS", "FAILURE"}:   "failed",
+	{"UNSTABLE", "SUCCESS"}:  "successfulAgain",
+	{"UNSTABLE", "UNSTABLE"}: "stillUnstable",
+	{"UNSTABLE", "FAILURE"}:  "failed",
+	{"FAILURE", "SUCCESS"}:   "successfulAgain",
+	{"FAILURE", "UNSTABLE"}:  "atLeastUnstable",
+	{"FAILURE", "FAILURE"}:   "stillFailing",
+}
+
+var resultSeverities = map[string]eventSeverity{
+	"SUCCESS":  severityInfo,
+	"UNSTABLE": severityWarning,
+	"FAILURE":  severityError,
+}
+
+// newJobEvent compares the last completed builds of two job states and returns the event
+// to report, or nil if the transition is not worth a notification.
+func newJobEvent(oldJob, newJob *job) *jobEvent {
+	if oldJob.LastCompletedBuild.Label >= newJob.LastCompletedBuild.Label {
+		return nil
+	}
+	oldStatus := oldJob.LastCompletedBuild.Result
+	newStatus := newJob.LastCompletedBuild.Result
+	id, ok := transitionMessages[[2]string{oldStatus, newStatus}]
+	cfg := getConfig()
+	if !ok || id == "stillSuccessful" && !cfg.SuccessiveSuccessful {
+		return nil
+	}
+	if groups := cfg.GroupSettings(newJob.Jenkins, newJob.Name); !groups.Notifies(id) {
+		return nil
+	}
+	data := newMessageData(newJob)
+	data.PreviousStatus = oldStatus
+	return &jobEvent{
+		Time:      time.Now(),
+		Job:       newJob,
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		Severity:  resultSeverities[newStatus],
+		Message:   notifyMessages().format(id, data),
+	}
+}
+
+// jobPatterns select jobs by name.
+type jobPatterns struct {
+	// all is set if no patterns are configured.
+	all     bool
+	regexes []*regexp.Regexp
+}
+
+// compileJobPatterns compiles case insensitive job name patterns, skipping invalid ones. If
+// patterns are configured but none is valid, no job matches.
+func compileJobPatterns(patterns []string, owner string) jobPatterns {
+	compiled := jobPatterns{all: len(patterns) == 0}
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			log.Println(owner+": ignoring invalid job pattern", pattern, err)
+			continue
+		}
+		compiled.regexes = append(compiled.regexes, regex)
+	}
+	if !compiled.all && len(compiled.regexes) == 0 {
+		log.Println(owner + ": no valid job pattern, matching no job")
+	}
+	return compiled
+}
+
+// matchesJob reports whether name matches one of the patterns. Without configured patterns
+// every job matches.
+func matchesJob(patterns jobPatterns, name string) bool {
+	if patterns.all {
+		return true
+	}
+	for _, pattern := range patterns.regexes {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcomes of an event that has not been handed to the sinks right away.
+const (
+	outcomeDeliver     = ""
+	outcomeHeld        = "held"
+	outcomeMuted       = "muted"
+	outcomeDropped     = "dropped during quiet hours"
+	outcomeRateLimited = "rate limited"
+)
+
+// pipeline is the part of the event handling built from the settings. It is replaced as a
+// whole when the settings change.
+type pipeline struct {
+	notifiers  []notifier
+	observers  []stateObserver
+	quietHours *quietHours
+	batcher    *batcher
+	escalator  *escalator
+	polling    pollingSchedule
+}
+
+// setupPipeline builds the pipeline from the current settings and stops the sinks of the
+// previous one. Events held by the previous batcher or quiet hours are released into the
+// new pipeline.
+func (m *jobModel) setupPipeline() {
+	currentMessages.Store(loadMessages())
+	p := &pipeline{
+		notifiers: setupNotifiers(m.ni),
+		observers: setupObservers(m.tray),
+		polling:   getPollingSchedule(),
+	}
+	p.quietHours = newQuietHours(getQuietHours(), m.deliver)
+	p.batcher = newBatcher(getBatchSettings(), m.release)
+	p.escalator = newEscalator(getEscalationSettings(), m.dispatch, m.notifyAll, m.muted)
+	old, _ := m.pipe.Load().(*pipeline)
+	if old != nil && old.escalator != nil && p.escalator != nil {
+		p.escalator.adopt(old.escalator)
+	}
+	m.pipe.Store(p)
+	if old != nil {
+		old.close()
+	}
+}
+
+func (m *jobModel) pipeline() *pipeline {
+	return m.pipe.Load().(*pipeline)
+}
+
+func (p *pipeline) close() {
+	for _, n := range p.notifiers {
+		if c, ok := n.(closer); ok {
+			c.Close()
+		}
+	}
+	for _, o := range p.observers {
+		if c, ok := o.(closer); ok {
+			c.Close()
+		}
+	}
+	if p.escalator != nil {
+		p.escalator.Close()
+	}
+}
+
+// dispatch hands an event to the sinks unless it is muted or held back by flood protection.
+func (m *jobModel) dispatch(ev *jobEvent) {
+	if m.muted(ev) {
+		m.suppress(ev, outcomeMuted)
+		return
+	}
+	p := m.pipeline()
+	if p.batcher != nil {
+		if outcome := p.batcher.admit(ev, ev.Time); outcome != outcomeDeliver {
+			m.suppress(ev, outcome)
+			return
+		}
+	}
+	m.release(ev)
+}
+
+// muted reports whether the job of the event or one of its groups is muted.
+func (m *jobModel) muted(ev *jobEvent) bool {
+	return m.mutes.isMuted(ev.Job, ev.Time) || getConfig().GroupSettings(ev.Job.Jenkins, ev.Job.Name).Muted
+}
+
+// release hands an event to the sinks unless quiet hours defer or drop it. Events held by the
+// batcher are admitted at the time they are released, not at the time they happened.
+func (m *jobModel) release(ev *jobEvent) {
+	if q := m.pipeline().quietHours; q != nil {
+		if outcome := q.admit(ev, time.Now()); outcome != outcomeDeliver {
+			m.suppress(ev, outcome)
+			return
+		}
+	}
+	m.deliver(ev)
+}
+
+// suppress records an event that was not delivered. Held events are recorded once they are
+// released.
+func (m *jobModel) suppress(ev *jobEvent, outcome string) {
+	if outcome == outcomeHeld {
+		return
+	}
+	log.Println(ev.Message, "("+outcome+")")
+	m.history.record(ev, outcome, nil)
+}
+
+// deliver hands an event to the sinks its groups allow.
+func (m *jobModel) deliver(ev *jobEvent) {
+	var targets []sinkEvent
+	for _, n := range m.pipeline().notifiers {
+		if sent := eventFor(ev, n.Name()); sent != nil {
+			targets = append(targets, sinkEvent{sink: n, ev: sent})
+		}
+	}
+	m.notify(ev, targets)
+}
+
+// sinkEvent is the part of an event a sink receives.
+type sinkEvent struct {
+	sink notifier
+	ev   *jobEvent
+}
+
+// notifyAll hands an event to all sinks and records it in the history.
+func (m *jobModel) notifyAll(ev *jobEvent, sinks []notifier) {
+	targets := make([]sinkEvent, len(sinks))
+	for i, n := range sinks {
+		targets[i] = sinkEvent{sink: n, ev: ev}
+	}
+	m.notify(ev, targets)
+}
+
+// notify hands the parts of an event to their sinks and records the event in the history
+// once all of them finished.
+func (m *jobModel) notify(ev *jobEvent, targets []sinkEvent) {
+	var mutex sync.Mutex
+	deliveries := make([]sinkResult, len(targets))
+	finished := joinDone(len(targets), func(error) {
+		m.history.record(ev, outcomeDeliver, deliveries)
+	})
+	for i, target := range targets {
+		i, n := i, target.sink
+		n.Notify(target.ev, func(err error) {
+			delivery := sinkResult{Sink: n.Name()}
+			if err != nil {
+				log.Println(n.Name(), "notification failed:", err)
+				delivery.Error = err.Error()
+			}
+			mutex.Lock()
+			deliveries[i] = delivery
+			mutex.Unlock()
+			finished(err)
+		})
+	}
+}
+
+// eventFor returns the part of the event the groups of its jobs let the sink receive, or nil
+// if there is none. Grouped events are summarized again for the jobs the sink receives.
+func eventFor(ev *jobEvent, sink string) *jobEvent {
+	cfg := getConfig()
+	members := ev.members()
+	if len(members) == 0 {
+		return ev
+	}
+	var allowed []*jobEvent
+	for _, member := range members {
+		if member.Instance {
+			allowed = append(allowed, member)
+			continue
+		}
+		groups := cfg.GroupSettings(member.Job.Jenkins, member.Job.Name)
+		if groups.DeliversTo(sink) {
+			allowed = append(allowed, member)
+		}
+	}
+	switch {
+	case len(allowed) == len(members):
+		return ev
+	case len(allowed) == 0:
+		return nil
+	case len(allowed) == 1:
+		return allowed[0]
+	default:
+		return newGroupEvent(summarizeEvents(allowed), allowed)
+	}
+}
+
+func setupObservers(tray *trayIcon) []stateObserver {
+	observers := []stateObserver{tray}
+	if mqtt := newMQTTPublisher(getMQTTSettings()); mqtt != nil {
+		observers = append(observers, mqtt)
+	}
+	return observers
+}
+
+func setupNotifiers(ni *walk.NotifyIcon) []notifier {
+	notifiers := []notifier{&balloonNotifier{ni: ni}}
+	if mail := newMailNotifier(getMailSettings()); mail != nil {
+		notifiers = append(notifiers, mail)
+	}
+	if hooks := newExecNotifier(getExecHookSettings()); hooks != nil {
+		notifiers = append(notifiers, hooks)
+	}
+	return notifiers
+}
+
+// balloonNotifier shows events as balloon messages of the notify icon.
+type balloonNotifier struct {
+	ni *walk.NotifyIcon
+}
+
+func (b *balloonNotifier) Name() string {
+	return "balloon"
+}
+
+func (b *balloonNotifier) Notify(ev *jobEvent, done func(err error)) {
+	appName := walk.App().ProductName()
+	switch ev.Severity {
+	case severityError:
+		done(b.ni.ShowError(appName, ev.Message))
+	case severityWarning:
+		done(b.ni.ShowWarning(appName, ev.Message))
+	default:
+		done(b.ni.ShowInfo(appName, ev.Message))
+	}
+}
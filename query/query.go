@@ -0,0 +1,485 @@
+// Package query parses and evaluates the filter queries of the job lists, for example
+//
+//	status:failure instance:jenkins-b name:/^product-/ building:true age>2d
+//
+// Terms are combined with AND, OR and NOT (or -term) and grouped with parentheses. Adjacent
+// terms are combined with AND. A term without a field matches the job name.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Item is the part of a job a query is evaluated on.
+type Item struct {
+	Name   string
+	Status string
+	// Instance is the URL of the Jenkins the job belongs to.
+	Instance string
+	// InstanceName is the short name of the instance, e.g. its host.
+	InstanceName string
+	Building     bool
+	Claimed      bool
+	Muted        bool
+	Groups       []string
+	// Finished is the time the last completed build finished, zero if there is none.
+	Finished time.Time
+}
+
+// Query is a parsed query.
+type Query struct {
+	root node
+}
+
+// Match reports whether the item matches the query. The empty query matches every item.
+func (q *Query) Match(item *Item, now time.Time) bool {
+	if q == nil || q.root == nil {
+		return true
+	}
+	return q.root.match(item, now)
+}
+
+// Empty reports whether the query matches every item.
+func (q *Query) Empty() bool {
+	return q == nil || q.root == nil
+}
+
+// SyntaxError describes an invalid query.
+type SyntaxError struct {
+	// Pos is the byte offset in the query.
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos+1, e.Msg)
+}
+
+type node interface {
+	match(item *Item, now time.Time) bool
+}
+
+type andNode []node
+
+func (n andNode) match(item *Item, now time.Time) bool {
+	for _, child := range n {
+		if !child.match(item, now) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []node
+
+func (n orNode) match(item *Item, now time.Time) bool {
+	for _, child := range n {
+		if child.match(item, now) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct {
+	child node
+}
+
+func (n notNode) match(item *Item, now time.Time) bool {
+	return !n.child.match(item, now)
+}
+
+type matchFunc func(item *Item, now time.Time) bool
+
+func (f matchFunc) match(item *Item, now time.Time) bool {
+	return f(item, now)
+}
+
+// Parse parses a query. An empty or blank query matches every item.
+func Parse(query string) (*Query, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, end: len(query)}
+	if len(tokens) == 0 {
+		return &Query{}, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return &Query{root: root}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenTerm tokenKind = iota
+	tokenOpen
+	tokenClose
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind tokenKind
+	pos  int
+	text string
+}
+
+// tokenize splits the query at white space and parentheses. Quoted values and regular
+// expressions between slashes may contain both.
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, pos: i, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, pos: i, text: ")"})
+			i++
+		case c == '-' && i+1 < len(query) && query[i+1] != ' ':
+			tokens = append(tokens, token{kind: tokenNot, pos: i, text: "-"})
+			i++
+		default:
+			start := i
+			var b strings.Builder
+			for i < len(query) {
+				c := query[i]
+				if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(' || c == ')' {
+					break
+				}
+				if c == '"' || c == '/' && (i == start || strings.ContainsRune(":=<>", rune(query[i-1]))) {
+					end := closing(query, i)
+					if end < 0 {
+						return nil, &SyntaxError{Pos: i, Msg: fmt.Sprintf("missing closing %c", c)}
+					}
+					b.WriteString(query[i : end+1])
+					i = end + 1
+					continue
+				}
+				b.WriteByte(c)
+				i++
+			}
+			text := b.String()
+			kind := tokenTerm
+			switch strings.ToUpper(text) {
+			case "AND", "&&":
+				kind = tokenAnd
+			case "OR", "||":
+				kind = tokenOr
+			case "NOT", "!":
+				kind = tokenNot
+			}
+			tokens = append(tokens, token{kind: kind, pos: start, text: text})
+		}
+	}
+	return tokens, nil
+}
+
+// closing returns the index of the quote or slash closing the one at start, skipping
+// escaped characters, or -1.
+func closing(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	end    int
+}
+
+func (p *parser) peek() *token {
+	if p.next >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.next]
+}
+
+func (p *parser) parseOr() (node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := orNode{first}
+	for t := p.peek(); t != nil && t.kind == tokenOr; t = p.peek() {
+		p.next++
+		child, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return children, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	children := andNode{first}
+	for t := p.peek(); t != nil && t.kind != tokenOr && t.kind != tokenClose; t = p.peek() {
+		if t.kind == tokenAnd {
+			p.next++
+		}
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return children, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, &SyntaxError{Pos: p.end, Msg: "unexpected end of query"}
+	}
+	p.next++
+	switch t.kind {
+	case tokenNot:
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child}, nil
+	case tokenOpen:
+		child, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); closing == nil || closing.kind != tokenClose {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "missing closing parenthesis"}
+		}
+		p.next++
+		return child, nil
+	case tokenTerm:
+		return parseTerm(t)
+	default:
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %q", t.text)}
+	}
+}
+
+var termPattern = regexp.MustCompile(`^([a-zA-Z]+)(:|>=|<=|>|<|=)(.*)$`)
+
+// comparisons are the operators of fields that can be compared.
+var comparisons = map[string]bool{">": true, "<": true, ">=": true, "<=": true, "=": true}
+
+func parseTerm(t *token) (node, error) {
+	parts := termPattern.FindStringSubmatch(t.text)
+	if parts == nil {
+		return parseName(t)
+	}
+	field, op, value := strings.ToLower(parts[1]), parts[2], parts[3]
+	if value == "" {
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("missing value for %s", field)}
+	}
+	textOnly := func() error {
+		if op != ":" && op != "=" {
+			return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("%s cannot be compared with %s", field, op)}
+		}
+		return nil
+	}
+	switch field {
+	case "name":
+		if err := textOnly(); err != nil {
+			return nil, err
+		}
+		return parseText(t, value, func(item *Item) []string { return []string{item.Name} })
+	case "status":
+		if err := textOnly(); err != nil {
+			return nil, err
+		}
+		return parseStatus(t, value)
+	case "instance":
+		if err := textOnly(); err != nil {
+			return nil, err
+		}
+		return parseText(t, value, func(item *Item) []string { return []string{item.Instance, item.InstanceName} })
+	case "group":
+		if err := textOnly(); err != nil {
+			return nil, err
+		}
+		return parseText(t, value, func(item *Item) []string { return item.Groups })
+	case "building", "claimed", "muted":
+		if err := textOnly(); err != nil {
+			return nil, err
+		}
+		want, err := strconv.ParseBool(unquote(value))
+		if err != nil {
+			return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("%s must be true or false", field)}
+		}
+		return matchFunc(func(item *Item, now time.Time) bool {
+			switch field {
+			case "building":
+				return item.Building == want
+			case "claimed":
+				return item.Claimed == want
+			default:
+				return item.Muted == want
+			}
+		}), nil
+	case "age":
+		if !comparisons[op] {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "age needs one of > < >= <= ="}
+		}
+		d, err := parseDuration(value)
+		if err != nil {
+			return nil, &SyntaxError{Pos: t.pos, Msg: err.Error()}
+		}
+		return matchFunc(func(item *Item, now time.Time) bool {
+			if item.Finished.IsZero() {
+				return false
+			}
+			age := now.Sub(item.Finished)
+			switch op {
+			case ">":
+				return age > d
+			case "<":
+				return age < d
+			case ">=":
+				return age >= d
+			case "<=":
+				return age <= d
+			default:
+				return age.Truncate(unit(value)) == d
+			}
+		}), nil
+	default:
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unknown field %q", parts[1])}
+	}
+}
+
+// parseName handles terms without a field like the filters did before queries: the term is
+// matched as case insensitive regular expression against the name, or as substring if it
+// is not a valid one.
+func parseName(t *token) (node, error) {
+	name := func(item *Item) []string { return []string{item.Name} }
+	if strings.HasPrefix(t.text, `"`) || strings.HasPrefix(t.text, "/") {
+		return parseText(t, t.text, name)
+	}
+	if _, err := regexp.Compile(t.text); err != nil {
+		return parseText(t, `"`+t.text+`"`, name)
+	}
+	return parseText(t, "/"+t.text+"/", name)
+}
+
+// parseText matches a regular expression between slashes or a case insensitive substring
+// against the values.
+func parseText(t *token, value string, values func(item *Item) []string) (node, error) {
+	if len(value) >= 2 && value[0] == '/' && value[len(value)-1] == '/' {
+		regex, err := regexp.Compile("(?i)" + value[1:len(value)-1])
+		if err != nil {
+			return nil, &SyntaxError{Pos: t.pos, Msg: err.Error()}
+		}
+		return matchFunc(func(item *Item, now time.Time) bool {
+			for _, v := range values(item) {
+				if regex.MatchString(v) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}
+	needle := strings.ToLower(unquote(value))
+	return matchFunc(func(item *Item, now time.Time) bool {
+		for _, v := range values(item) {
+			if strings.Contains(strings.ToLower(v), needle) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// statuses maps the accepted status values to the Jenkins results.
+var statuses = map[string]string{
+	"success":  "SUCCESS",
+	"ok":       "SUCCESS",
+	"unstable": "UNSTABLE",
+	"failure":  "FAILURE",
+	"failed":   "FAILURE",
+	"aborted":  "ABORTED",
+	"none":     "",
+	"unknown":  "",
+}
+
+func parseStatus(t *token, value string) (node, error) {
+	if strings.HasPrefix(value, "/") {
+		return parseText(t, value, func(item *Item) []string { return []string{item.Status} })
+	}
+	status, ok := statuses[strings.ToLower(unquote(value))]
+	if !ok {
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unknown status %q", value)}
+	}
+	return matchFunc(func(item *Item, now time.Time) bool {
+		return item.Status == status
+	}), nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(value)
+	}
+	return value
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+var durationPattern = regexp.MustCompile(`^(\d+)([smhdw])$`)
+
+// parseDuration parses durations like 30m, 2d or 1w.
+func parseDuration(value string) (time.Duration, error) {
+	parts := durationPattern.FindStringSubmatch(strings.ToLower(value))
+	if parts == nil {
+		return 0, fmt.Errorf("invalid duration %q, use a number with s, m, h, d or w", value)
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * units[parts[2]], nil
+}
+
+// unit returns the unit of a valid duration.
+func unit(value string) time.Duration {
+	return units[strings.ToLower(value[len(value)-1:])]
+}
@@ -0,0 +1,175 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+var now = time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+
+var items = map[string]*Item{
+	"productFailed": {
+		Name:         "product-build",
+		Status:       "FAILURE",
+		Instance:     "http://jenkins-b:8080/view/All",
+		InstanceName: "jenkins-b:8080",
+		Building:     true,
+		Groups:       []string{"product", "nightly"},
+		Finished:     now.Add(-3 * 24 * time.Hour),
+	},
+	"toolsOK": {
+		Name:         "tools (release)",
+		Status:       "SUCCESS",
+		Instance:     "http://jenkins-a/",
+		InstanceName: "jenkins-a",
+		Claimed:      true,
+		Finished:     now.Add(-90 * time.Minute),
+	},
+	"quoted": {
+		Name:         `say "hi" now`,
+		Status:       "UNSTABLE",
+		Instance:     "http://jenkins-a/",
+		InstanceName: "jenkins-a",
+		Muted:        true,
+	},
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"productFailed", "toolsOK", "quoted"}},
+		{"   ", []string{"productFailed", "toolsOK", "quoted"}},
+		{"product", []string{"productFailed"}},
+		{"PRODUCT", []string{"productFailed"}},
+		{"^tools", []string{"toolsOK"}},
+		// parentheses group unless quoted
+		{"tools (release)", []string{"toolsOK"}},
+		{"product (release)", nil},
+		{`"tools (release)"`, []string{"toolsOK"}},
+		{`name:"(release)"`, []string{"toolsOK"}},
+		{`name:"say \"hi\""`, []string{"quoted"}},
+		{"name:/^product-/", []string{"productFailed"}},
+		{`name:/ \(rel/`, []string{"toolsOK"}},
+		{"status:failure", []string{"productFailed"}},
+		{"status:FAILED", []string{"productFailed"}},
+		{"status:/^(SUCCESS|UNSTABLE)$/", []string{"toolsOK", "quoted"}},
+		{"instance:jenkins-b", []string{"productFailed"}},
+		{"instance:8080/view", []string{"productFailed"}},
+		{"group:nightly", []string{"productFailed"}},
+		{"building:true", []string{"productFailed"}},
+		{"claimed:true", []string{"toolsOK"}},
+		{"muted:false", []string{"productFailed", "toolsOK"}},
+		{"age>2d", []string{"productFailed"}},
+		{"age<2h", []string{"toolsOK"}},
+		{"age>=3d", []string{"productFailed"}},
+		{"age<=1h", nil},
+		{"age=3d", []string{"productFailed"}},
+		{"age=1h", []string{"toolsOK"}},
+		{"NOT status:failure", []string{"toolsOK", "quoted"}},
+		{"-status:failure", []string{"toolsOK", "quoted"}},
+		{"! building:true", []string{"toolsOK", "quoted"}},
+		{"instance:jenkins-a muted:true", []string{"quoted"}},
+		{"instance:jenkins-a AND muted:true", []string{"quoted"}},
+		{"instance:jenkins-a && muted:true", []string{"quoted"}},
+		{"status:failure OR claimed:true", []string{"productFailed", "toolsOK"}},
+		{"status:failure || claimed:true", []string{"productFailed", "toolsOK"}},
+		// AND binds stronger than OR
+		{"status:failure OR instance:jenkins-a muted:true", []string{"productFailed", "quoted"}},
+		{"muted:true instance:jenkins-a OR status:failure", []string{"productFailed", "quoted"}},
+		{"(status:failure OR instance:jenkins-a) muted:true", []string{"quoted"}},
+		// NOT binds stronger than AND and OR
+		{"NOT status:failure OR building:true", []string{"productFailed", "toolsOK", "quoted"}},
+		{"NOT (status:failure OR muted:true)", []string{"toolsOK"}},
+		{"NOT NOT muted:true", []string{"quoted"}},
+		{"-(instance:jenkins-a)", []string{"productFailed"}},
+		{"((status:success))", []string{"toolsOK"}},
+	}
+	for _, test := range tests {
+		q, err := Parse(test.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.query, err)
+			continue
+		}
+		want := make(map[string]bool)
+		for _, name := range test.want {
+			want[name] = true
+		}
+		for name, item := range items {
+			if got := q.Match(item, now); got != want[name] {
+				t.Errorf("Parse(%q).Match(%s) = %v, want %v", test.query, name, got, want[name])
+			}
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{" \t", true},
+		{"x", false},
+	}
+	for _, test := range tests {
+		q, err := Parse(test.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.query, err)
+			continue
+		}
+		if got := q.Empty(); got != test.want {
+			t.Errorf("Parse(%q).Empty() = %v, want %v", test.query, got, test.want)
+		}
+	}
+	var q *Query
+	if !q.Empty() || !q.Match(items["toolsOK"], now) {
+		t.Error("the nil query must be empty and match every item")
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{`name:"open`, 5, "missing closing \""},
+		{"name:/open", 5, "missing closing /"},
+		{"(status:failure", 0, "missing closing parenthesis"},
+		{"a (b", 2, "missing closing parenthesis"},
+		{"status:failure)", 14, `unexpected ")"`},
+		{")", 0, `unexpected ")"`},
+		{"a OR", 4, "unexpected end of query"},
+		{"NOT", 3, "unexpected end of query"},
+		{"a AND OR b", 6, `unexpected "OR"`},
+		{"OR a", 0, `unexpected "OR"`},
+		{"status:", 0, "missing value for status"},
+		{"x status:broken", 2, `unknown status "broken"`},
+		{"colour:red", 0, `unknown field "colour"`},
+		{"name>a", 0, "name cannot be compared with >"},
+		{"building:maybe", 0, "building must be true or false"},
+		{"age:2d", 0, "age needs one of > < >= <= ="},
+		{"age>2y", 0, `invalid duration "2y", use a number with s, m, h, d or w`},
+		{"a name:/(/", 2, "error parsing regexp: missing closing ): `(?i)(`"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.query)
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%q) = %v, want a SyntaxError", test.query, err)
+			continue
+		}
+		if syntaxErr.Pos != test.pos || syntaxErr.Msg != test.msg {
+			t.Errorf("Parse(%q) = %d %q, want %d %q", test.query, syntaxErr.Pos, syntaxErr.Msg, test.pos, test.msg)
+		}
+	}
+}
+
+func TestSyntaxErrorMessage(t *testing.T) {
+	err := &SyntaxError{Pos: 4, Msg: "unexpected end of query"}
+	if got, want := err.Error(), "position 5: unexpected end of query"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"JenkinsCheck/config"
+)
+
+// applyRules monitors the jobs selected by the job rules that are not monitored yet and
+// drops dynamic jobs that are gone or no longer selected. Dynamic jobs of unreachable
+// instances are kept. It reports whether jobs were added or dropped.
+func (m *jobModel) applyRules(cfg *config.Config, polled jobs) bool {
+	rules, err := cfg.Rules()
+	if err != nil {
+		// already reported when the settings were loaded
+		rules = nil
+	}
+	unreachable := make(map[string]bool, len(polled.Unreachable))
+	for _, url := range polled.Unreachable {
+		unreachable[url] = true
+	}
+
+	changed := false
+	items := make([]*job, 0, len(m.jobs))
+	for _, item := range m.jobs {
+		if item.Dynamic && !unreachable[item.Jenkins] {
+			current := findJob(polled.Jobs, item)
+			if current == nil || !rules.Match(current.Jenkins, current.Name, current.URL) {
+				changed = true
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	if !rules.Empty() {
+		for _, j := range polled.Jobs {
+			if unreachable[j.Jenkins] || findJob(items, j) != nil || !rules.Match(j.Jenkins, j.Name, j.URL) {
+				continue
+			}
+			items = append(items, &job{
+				Name:    j.Name,
+				Jenkins: j.Jenkins,
+				URL:     j.URL,
+				Dynamic: true,
+			})
+			changed = true
+		}
+	}
+	if !changed {
+		return false
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
+	})
+	m.jobs = items
+	return true
+}
+
+func findJob(items []*job, j *job) *job {
+	for _, item := range items {
+		if item.Name == j.Name && item.Jenkins == j.Jenkins {
+			return item
+		}
+	}
+	return nil
+}
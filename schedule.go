@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+// scheduleWindow is a recurring time span of a week. A window that ends before it starts
+// runs past midnight into the next day, a window with equal From and To spans the whole day.
+type scheduleWindow struct {
+	// Days are abbreviated weekdays ("Mon", "Tue", ...) the window starts on. Empty means
+	// every day.
+	Days []string
+	// From and To are times of the day in the format 15:04.
+	From string
+	To   string
+}
+
+// quietWindow is a time span in which notifications are not delivered immediately.
+type quietWindow struct {
+	scheduleWindow
+	// Action is "defer" (the default) to deliver a summary when the window ends or "drop"
+	// to discard notifications.
+	Action string
+}
+
+const (
+	quietDefer = "defer"
+	quietDrop  = "drop"
+)
+
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *scheduleWindow) validate() error {
+	if _, err := parseClock(w.From); err != nil {
+		return fmt.Errorf("invalid start %q: %w", w.From, err)
+	}
+	if _, err := parseClock(w.To); err != nil {
+		return fmt.Errorf("invalid end %q: %w", w.To, err)
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("invalid day %q", day)
+		}
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (w *scheduleWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *scheduleWindow) contains(t time.Time) bool {
+	from, err := parseClock(w.From)
+	if err != nil {
+		return false
+	}
+	to, err := parseClock(w.To)
+	if err != nil {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	switch {
+	case from == to:
+		return w.onDay(t.Weekday())
+	case from < to:
+		return w.onDay(t.Weekday()) && minute >= from && minute < to
+	default:
+		return w.onDay(t.Weekday()) && minute >= from ||
+			w.onDay(t.AddDate(0, 0, -1).Weekday()) && minute < to
+	}
+}
+
+// validWindows drops and logs windows that cannot be parsed.
+func validWindows(windows []scheduleWindow, owner string) []scheduleWindow {
+	var valid []scheduleWindow
+	for _, w := range windows {
+		if err := w.validate(); err != nil {
+			log.Println(owner+": ignoring window:", err)
+			continue
+		}
+		valid = append(valid, w)
+	}
+	return valid
+}
+
+// pollingSchedule restricts polling to the configured windows. Without windows polling is
+// always active.
+type pollingSchedule []scheduleWindow
+
+func getPollingSchedule() pollingSchedule {
+	settings := walk.App().Settings()
+	var windows []scheduleWindow
+	windowsStr, ok := settings.Get("PollingHours")
+	if !ok || windowsStr == "" {
+		return nil
+	}
+	err := json.Unmarshal([]byte(windowsStr), &windows)
+	if err != nil {
+		log.Println("getPollingSchedule:", err)
+	}
+	return pollingSchedule(validWindows(windows, "polling hours"))
+}
+
+func (s pollingSchedule) active(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, w := range s {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// quietHours defers or drops notifications inside the configured windows. Deferred events
+// are delivered as one summary when the quiet time is over.
+type quietHours struct {
+	mutex    sync.Mutex
+	windows  []quietWindow
+	deferred []*jobEvent
+	timer    *time.Timer
+	deliver  func(ev *jobEvent)
+}
+
+// newQuietHours returns nil if no quiet windows are configured.
+func newQuietHours(windows []quietWindow, deliver func(ev *jobEvent)) *quietHours {
+	q := &quietHours{deliver: deliver}
+	for _, w := range windows {
+		if err := w.validate(); err != nil {
+			log.Println("quiet hours: ignoring window:", err)
+			continue
+		}
+		switch w.Action {
+		case "":
+			w.Action = quietDefer
+		case quietDefer, quietDrop:
+		default:
+			log.Println("quiet hours: unknown action", w.Action, "deferring instead")
+			w.Action = quietDefer
+		}
+		q.windows = append(q.windows, w)
+	}
+	if len(q.windows) == 0 {
+		return nil
+	}
+	return q
+}
+
+func getQuietHours() []quietWindow {
+	settings := walk.App().Settings()
+	var windows []quietWindow
+	windowsStr, ok := settings.Get("QuietHours")
+	if !ok || windowsStr == "" {
+		return nil
+	}
+	err := json.Unmarshal([]byte(windowsStr), &windows)
+	if err != nil {
+		log.Println("getQuietHours:", err)
+	}
+	return windows
+}
+
+// action returns the action of the first window containing t or "" if t is not quiet.
+func (q *quietHours) action(t time.Time) string {
+	for _, w := range q.windows {
+		if w.contains(t) {
+			return w.Action
+		}
+	}
+	return ""
+}
+
+// end returns the first minute after t that is not quiet, looking at most a week ahead.
+func (q *quietHours) end(t time.Time) time.Time {
+	end := t.Truncate(time.Minute)
+	for i := 0; i <= 7*24*60; i++ {
+		end = end.Add(time.Minute)
+		if q.action(end) == "" {
+			return end
+		}
+	}
+	return end
+}
+
+// admit returns outcomeDeliver if the event may be delivered now. Otherwise it has been
+// deferred or dropped.
+func (q *quietHours) admit(ev *jobEvent, now time.Time) string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	switch q.action(now) {
+	case quietDrop:
+		return outcomeDropped
+	case quietDefer:
+		q.deferred = append(q.deferred, ev)
+		if q.timer == nil {
+			q.timer = time.AfterFunc(q.end(now).Sub(now), q.flush)
+		}
+		return outcomeHeld
+	}
+	return outcomeDeliver
+}
+
+func (q *quietHours) flush() {
+	defer handlePanic()
+	q.mutex.Lock()
+	now := time.Now()
+	if q.action(now) == quietDefer {
+		// woken up early, e.g. by a changed clock
+		q.timer = time.AfterFunc(q.end(now).Sub(now), q.flush)
+		q.mutex.Unlock()
+		return
+	}
+	deferred := q.deferred
+	q.deferred = nil
+	q.timer = nil
+	q.mutex.Unlock()
+
+	switch len(deferred) {
+	case 0:
+	case 1:
+		q.deliver(deferred[0])
+	default:
+		messages := make([]string, len(deferred))
+		for i, ev := range deferred {
+			messages[i] = ev.Message
+		}
+		header := notifyMessages().format("quietHours", messageData{Count: len(deferred)})
+		q.deliver(newGroupEvent(header+"\n"+strings.Join(messages, "\n"), deferred))
+	}
+}
@@ -0,0 +1,155 @@
+// Package scheduler runs the polls of the monitored jobs. Polls never overlap, requests to
+// poll now are coalesced, and interval changes take effect at once.
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of a Scheduler, replaced by a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of time.Timer used by a Scheduler.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// RealClock is the system clock.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// Scheduler calls a poll function at an interval. The first poll runs when it is started.
+type Scheduler struct {
+	clock Clock
+	poll  func(now time.Time)
+
+	mutex    sync.Mutex
+	interval time.Duration
+	started  bool
+
+	reconfigured chan struct{}
+	triggered    chan struct{}
+	stop         chan struct{}
+	stopOnce     sync.Once
+	done         chan struct{}
+}
+
+// New returns a stopped Scheduler calling poll every interval.
+func New(clock Clock, interval time.Duration, poll func(now time.Time)) *Scheduler {
+	return &Scheduler{
+		clock:        clock,
+		poll:         poll,
+		interval:     interval,
+		reconfigured: make(chan struct{}, 1),
+		triggered:    make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// Start runs the first poll and schedules the next ones. A Scheduler starts only once.
+func (s *Scheduler) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	go s.run()
+}
+
+// Stop ends the scheduling and waits for a running poll to finish.
+func (s *Scheduler) Stop() {
+	s.mutex.Lock()
+	started := s.started
+	s.started = true
+	s.mutex.Unlock()
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	if !started {
+		close(s.done)
+	}
+	<-s.done
+}
+
+// Reconfigure changes the interval. The next poll is due one new interval after the last one.
+func (s *Scheduler) Reconfigure(interval time.Duration) {
+	s.mutex.Lock()
+	s.interval = interval
+	s.mutex.Unlock()
+	signal(s.reconfigured)
+}
+
+// TriggerNow polls as soon as possible. Requests made while a poll is pending or running
+// result in a single further poll.
+func (s *Scheduler) TriggerNow() {
+	signal(s.triggered)
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) currentInterval() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.interval
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+	var last time.Time
+	next := s.clock.Now()
+	for {
+		timer := s.clock.NewTimer(next.Sub(s.clock.Now()))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.reconfigured:
+			timer.Stop()
+			if !last.IsZero() {
+				next = last.Add(s.currentInterval())
+			}
+			continue
+		case <-s.triggered:
+			timer.Stop()
+		case <-timer.C():
+		}
+		// a stop requested together with the poll takes precedence
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		last = s.clock.Now()
+		s.poll(last)
+		next = last.Add(s.currentInterval())
+	}
+}
@@ -0,0 +1,243 @@
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when advanced. Timers created with a delay of zero or less fire at
+// once.
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	created chan time.Duration
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	c       chan time.Time
+	at      time.Time
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC),
+		created: make(chan time.Duration, 100),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), at: c.now.Add(d)}
+	if d <= 0 {
+		t.c <- c.now
+	} else {
+		c.timers = append(c.timers, t)
+	}
+	c.created <- d
+	return t
+}
+
+// Advance moves the time and fires the timers due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		switch {
+		case t.stopped:
+		case !t.at.After(c.now):
+			t.c <- c.now
+		default:
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+}
+
+// waitIdle waits until the scheduler waits for a timer of the given delay.
+func (c *fakeClock) waitIdle(t *testing.T, want time.Duration) {
+	t.Helper()
+	for {
+		select {
+		case d := <-c.created:
+			if d == want {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the scheduler did not wait for %v", want)
+		}
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	wasActive := !t.stopped && len(t.c) == 0
+	t.stopped = true
+	return wasActive
+}
+
+// recorder collects the polls. Each poll blocks until released if block is set.
+type recorder struct {
+	polls   chan time.Time
+	block   bool
+	release chan struct{}
+}
+
+func newRecorder(block bool) *recorder {
+	return &recorder{polls: make(chan time.Time, 10), block: block, release: make(chan struct{})}
+}
+
+func (r *recorder) poll(now time.Time) {
+	r.polls <- now
+	if r.block {
+		<-r.release
+	}
+}
+
+func (r *recorder) next(t *testing.T) time.Time {
+	t.Helper()
+	select {
+	case now := <-r.polls:
+		return now
+	case <-time.After(time.Second):
+		t.Fatal("no poll")
+		return time.Time{}
+	}
+}
+
+func (r *recorder) none(t *testing.T) {
+	t.Helper()
+	select {
+	case now := <-r.polls:
+		t.Fatalf("unexpected poll at %v", now)
+	default:
+	}
+}
+
+func TestStartPollsAtOnce(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	r := newRecorder(false)
+	s := New(clock, 30*time.Second, r.poll)
+	s.Start()
+	defer s.Stop()
+
+	if got := r.next(t); !got.Equal(start) {
+		t.Errorf("first poll at %v, want %v", got, start)
+	}
+	clock.waitIdle(t, 30*time.Second)
+	r.none(t)
+	clock.Advance(30 * time.Second)
+	if got, want := r.next(t), start.Add(30*time.Second); !got.Equal(want) {
+		t.Errorf("second poll at %v, want %v", got, want)
+	}
+}
+
+func TestTriggerNowIsCoalesced(t *testing.T) {
+	clock := newFakeClock()
+	r := newRecorder(true)
+	s := New(clock, 30*time.Second, r.poll)
+	s.Start()
+	defer s.Stop()
+
+	r.next(t)
+	s.TriggerNow()
+	s.TriggerNow()
+	s.TriggerNow()
+	r.release <- struct{}{}
+	r.next(t)
+	r.release <- struct{}{}
+	clock.waitIdle(t, 30*time.Second)
+	r.none(t)
+
+	s.TriggerNow()
+	r.next(t)
+	r.release <- struct{}{}
+	clock.waitIdle(t, 30*time.Second)
+	r.none(t)
+}
+
+func TestReconfigureMovesNextPoll(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	r := newRecorder(false)
+	s := New(clock, 30*time.Second, r.poll)
+	s.Start()
+	defer s.Stop()
+
+	r.next(t)
+	clock.waitIdle(t, 30*time.Second)
+	clock.Advance(10 * time.Second)
+	s.Reconfigure(15 * time.Second)
+	clock.waitIdle(t, 5*time.Second)
+	clock.Advance(4 * time.Second)
+	r.none(t)
+	clock.Advance(time.Second)
+	if got, want := r.next(t), start.Add(15*time.Second); !got.Equal(want) {
+		t.Errorf("poll at %v, want %v", got, want)
+	}
+	clock.waitIdle(t, 15*time.Second)
+
+	// a shorter interval than already elapsed polls at once
+	clock.Advance(10 * time.Second)
+	s.Reconfigure(5 * time.Second)
+	if got, want := r.next(t), start.Add(25*time.Second); !got.Equal(want) {
+		t.Errorf("poll at %v, want %v", got, want)
+	}
+}
+
+func TestStopWaitsForRunningPoll(t *testing.T) {
+	clock := newFakeClock()
+	r := newRecorder(true)
+	s := New(clock, 30*time.Second, r.poll)
+	s.Start()
+	r.next(t)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned during a poll")
+	case <-time.After(50 * time.Millisecond):
+	}
+	r.release <- struct{}{}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return after the poll")
+	}
+
+	s.TriggerNow()
+	clock.Advance(time.Minute)
+	r.none(t)
+	s.Stop()
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	s := New(newFakeClock(), time.Second, func(time.Time) {
+		t.Error("unexpected poll")
+	})
+	s.Stop()
+	s.Start()
+	s.Stop()
+}
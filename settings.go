@@ -1,12 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"JenkinsCheck/config"
 
 	"github.com/lxn/walk"
 	. "github.com/lxn/walk/declarative"
@@ -39,18 +43,19 @@ func (mw *jenkinsMainWindow) openSettings() {
 	remote := new(listModel)
 	own := new(listModel)
 
-	jenkinsURLs := getJobsURLs()
+	cfg := getConfig()
+	var fileNote string
+	if s, ok := settings.(*fileSettings); ok && s.file.Defines(config.KeyURLPrefix, config.KeyJobs,
+		config.KeyInterval, config.KeySuccessiveSuccessful, config.KeyBrowser) {
+		fileNote = "Settings defined in " + s.path + " take precedence over the values below."
+	}
+	jenkinsURLs := cfg.URLs()
+	storedURLs := append([]string(nil), jenkinsURLs...)
 	urls.items = jenkinsURLs
 	var jenkinsURL string
 	if len(jenkinsURLs) > 0 {
 		jenkinsURL = jenkinsURLs[0]
 	}
-	browser, ok := settings.Get("Browser")
-	if !ok {
-		browser = ""
-	}
-	ssBuilds := getSuccessiveSuccessful()
-	interval := getInterval()
 
 	err := Dialog{
 		AssignTo:      &dlg.Dialog,
@@ -60,6 +65,10 @@ func (mw *jenkinsMainWindow) openSettings() {
 		CancelButton:  &dlg.cancelPB,
 		Layout:        VBox{},
 		Children: []Widget{
+			Label{
+				Text:    fileNote,
+				Visible: fileNote != "",
+			},
 			Composite{
 				Layout: Grid{Columns: 3},
 				Children: []Widget{
@@ -144,9 +153,9 @@ func (mw *jenkinsMainWindow) openSettings() {
 					},
 					LineEdit{
 						AssignTo: &dlg.browserBox,
-						Text:     browser,
+						Text:     cfg.Browser,
 						OnTextChanged: func() {
-							settings.Put("Browser", dlg.browserBox.Text())
+							settings.Put(config.KeyBrowser, dlg.browserBox.Text())
 						},
 					},
 					PushButton{
@@ -167,19 +176,19 @@ func (mw *jenkinsMainWindow) openSettings() {
 					Label{Text: "Interval (in seconds):"},
 					LineEdit{
 						AssignTo: &dlg.intervalBox,
-						Text:     strconv.Itoa(interval),
+						Text:     strconv.Itoa(cfg.Interval),
 						OnTextChanged: func() {
-							settings.Put("Interval", dlg.intervalBox.Text())
+							settings.Put(config.KeyInterval, dlg.intervalBox.Text())
 						},
 					},
 					HSpacer{},
 					Label{Text: "Notify after successive successful builds:"},
 					CheckBox{
 						AssignTo:   &dlg.ssBox,
-						Checked:    ssBuilds,
+						Checked:    cfg.SuccessiveSuccessful,
 						ColumnSpan: 2,
 						OnCheckedChanged: func() {
-							settings.Put("Successive_successful", strconv.FormatBool(dlg.ssBox.Checked()))
+							settings.Put(config.KeySuccessiveSuccessful, strconv.FormatBool(dlg.ssBox.Checked()))
 						},
 					},
 					VSeparator{ColumnSpan: 3},
@@ -201,7 +210,8 @@ func (mw *jenkinsMainWindow) openSettings() {
 						Children: []Widget{
 							Label{Text: "Filter:"},
 							LineEdit{
-								AssignTo: &dlg.remoteFilter,
+								AssignTo:    &dlg.remoteFilter,
+								ToolTipText: filterHelp,
 								OnTextChanged: func() {
 									remote.items = substractAndFilterArray(
 										dlg.allItems,
@@ -334,7 +344,8 @@ func (mw *jenkinsMainWindow) openSettings() {
 						Children: []Widget{
 							Label{Text: "Filter:"},
 							LineEdit{
-								AssignTo: &dlg.ownFilter,
+								AssignTo:    &dlg.ownFilter,
+								ToolTipText: filterHelp,
 								OnTextChanged: func() {
 									own.items = substractAndFilterArray(dlg.ownItems, []*job{}, dlg.ownFilter.Text())
 									own.PublishItemsReset()
@@ -388,6 +399,14 @@ func (mw *jenkinsMainWindow) openSettings() {
 						AssignTo: &dlg.okPB,
 						Text:     "Ok",
 						OnClicked: func() {
+							_, err := config.Load(settings)
+							if err := editedErrors(err, storedURLs); err != nil {
+								walk.MsgBox(dlg, "Invalid settings", err.Error(), walk.MsgBoxIconError)
+								return
+							}
+							if err != nil {
+								log.Println("settings:", err)
+							}
 							dlg.Close(walk.DlgCmdOK)
 						},
 					},
@@ -415,22 +434,7 @@ func (mw *jenkinsMainWindow) openSettings() {
 			job := jobs.Jobs[i]
 			allItems[i] = job
 		}
-		ownItems, migration := loadJobs()
-
-		// Settings migration from job names to job names + jenkins url
-		if migration {
-			for _, item := range ownItems {
-				if item.Jenkins != "" {
-					continue
-				}
-				for _, rItem := range allItems {
-					if item.Name == rItem.Name {
-						item.Jenkins = rItem.Jenkins
-						break
-					}
-				}
-			}
-		}
+		ownItems := loadJobs()
 
 		dlg.Synchronize(func() {
 			defer handlePanic()
@@ -454,22 +458,12 @@ func (mw *jenkinsMainWindow) openSettings() {
 }
 
 func (dlg *settingsWindow) saveUrls() {
-	var idx int
 	model, ok := dlg.URLLb.Model().(*urlsModel)
 	if !ok {
 		return
 	}
-	settings := walk.App().Settings()
-	for idx = 0; idx < len(model.items); idx++ {
-		settings.Put("URL_"+strconv.Itoa(idx), model.items[idx])
-	}
-	ok = true
-	for ; ok; idx++ {
-		key := "URL_" + strconv.Itoa(idx)
-		_, ok = settings.Get(key)
-		if ok {
-			settings.Remove("URL_" + strconv.Itoa(idx))
-		}
+	if err := config.PutURLs(walk.App().Settings(), model.items); err != nil {
+		log.Println("saveUrls:", err)
 	}
 }
 
@@ -507,28 +501,10 @@ func (m *listModel) Value(index int) interface{} {
 
 func substractAndFilterArray(allItems []*job, ownItems []*job, filter string) []*job {
 	remoteItems := []*job{}
-	regexSearch := true
-	regex, err := regexp.Compile("(?i)" + filter)
-	if err != nil {
-		regexSearch = false
-	}
+	q, _ := parseFilter(filter)
+	now := time.Now()
 	for i := 0; i < len(allItems); i++ {
-		skip := false
-		if filter != "" {
-			if regexSearch {
-				if regex.MatchString(allItems[i].Name) {
-					skip = false
-				} else {
-					skip = true
-				}
-			} else {
-				if strings.Contains(strings.ToLower(allItems[i].Name), strings.ToLower(filter)) {
-					skip = false
-				} else {
-					skip = true
-				}
-			}
-		}
+		skip := !q.Match(queryItem(allItems[i]), now)
 		for _, item := range ownItems {
 			if item.Name == allItems[i].Name && item.Jenkins == allItems[i].Jenkins {
 				skip = true
@@ -545,105 +521,134 @@ func substractAndFilterArray(allItems []*job, ownItems []*job, filter string) []
 	return remoteItems
 }
 
-type saveJob struct {
-	Name     string
-	Instance string
+var currentConfig atomic.Value
+
+// loadConfig reads the settings into the current configuration. Invalid settings are logged
+// and replaced by their defaults.
+func loadConfig() *config.Config {
+	cfg, err := config.Load(walk.App().Settings())
+	if err != nil {
+		log.Println("invalid settings:", err)
+	}
+	currentConfig.Store(cfg)
+	return cfg
 }
 
-func loadJobs() ([]*job, bool) {
-	settings := walk.App().Settings()
-	watchedJobsStr, ok := settings.Get("Jobs")
-	if ok {
-		var watchedJobs []saveJob
-		err := json.Unmarshal([]byte(watchedJobsStr), &watchedJobs)
-		if err == nil {
-			ownItems := make([]*job, len(watchedJobs))
-			for i, item := range watchedJobs {
-				ownItems[i] = &job{
-					Name:    item.Name,
-					Jenkins: item.Instance,
+// migrateSettings converts the settings of old versions once. Without resolve, it stops
+// before the migrations that query the instances and reports them as deferred.
+func migrateSettings(settings *fileSettings, resolve config.InstanceResolver) bool {
+	applied, err := config.Migrate(settings, settings.FilePath(), &config.MigrationEnv{
+		ResolveInstances: resolve,
+		StoreCredential:  storeCredential,
+	})
+	for _, m := range applied {
+		log.Printf("migrated settings to version %d: %s", m.Version, m.Description)
+	}
+	if err == config.ErrDeferred {
+		return true
+	}
+	if err != nil {
+		log.Println("migrating settings:", err)
+	}
+	return false
+}
+
+// migrateDeferred runs the migrations that query the instances once the window is up. The
+// instances are queried in the background, the settings are changed on the UI thread.
+func (mw *jenkinsMainWindow) migrateDeferred(settings *fileSettings) {
+	defer handlePanic()
+	instances, err := jobInstances()
+	if err != nil {
+		log.Println("migrating settings:", err)
+		return
+	}
+	mw.Synchronize(func() {
+		defer handlePanic()
+		migrateSettings(settings, func(names []string) (map[string]string, error) {
+			resolved := make(map[string]string, len(names))
+			for _, name := range names {
+				if instance, ok := instances[name]; ok {
+					resolved[name] = instance
 				}
 			}
-			return substractAndFilterArray(ownItems, []*job{}, ""), false
-		}
-
-		log.Println("loadJobs:", err)
-		var jobStrings []string
-		err = json.Unmarshal([]byte(watchedJobsStr), &jobStrings)
-		if err != nil {
-			log.Println("loadJobs2:", err)
-		}
+			return resolved, nil
+		})
+		mw.reInit()
+	})
+}
 
-		ownItems := make([]*job, len(jobStrings))
-		for i, item := range jobStrings {
-			ownItems[i] = &job{Name: item}
+// editedErrors returns the errors of the settings the settings dialog edits. Passwords in the
+// stored URLs are left to the migration, the others block.
+func editedErrors(err error, storedURLs []string) error {
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	var edited config.Errors
+	for _, e := range errs.Of(config.KeyInterval, config.KeySuccessiveSuccessful, config.KeyURLPrefix, config.KeyJobs) {
+		if errors.Is(e, config.ErrURLPassword) && contains(storedURLs, e.Value) {
+			continue
 		}
-		return substractAndFilterArray(ownItems, []*job{}, ""), true
+		edited = append(edited, e)
 	}
-	return []*job{}, false
+	if len(edited) == 0 {
+		return nil
+	}
+	return edited
 }
 
-func saveJobs(ownItems []*job) {
-	settings := walk.App().Settings()
-	watchedJobs := make([]saveJob, len(ownItems))
-	for i, item := range ownItems {
-		watchedJobs[i] = saveJob{
-			Name:     item.Name,
-			Instance: item.Jenkins,
+// jobInstances looks up the jobs on all instances and returns the instance of each job name.
+// Jobs on more than one instance get the first one.
+func jobInstances() (map[string]string, error) {
+	cfg, _ := config.Load(walk.App().Settings())
+	polled := getJobsFromMultiple(cfg.URLs())
+	if len(polled.Unreachable) > 0 {
+		return nil, fmt.Errorf("unreachable: %s", strings.Join(polled.Unreachable, ", "))
+	}
+	instances := make(map[string]string)
+	for _, j := range polled.Jobs {
+		if _, ok := instances[j.Name]; !ok {
+			instances[j.Name] = j.Jenkins
 		}
 	}
-	watchedJobsJSON, _ := json.Marshal(watchedJobs)
-	settings.Put("Jobs", string(watchedJobsJSON))
+	return instances, nil
 }
 
-func getInterval() int {
-	settings := walk.App().Settings()
-	var interval int
-	intervalStr, ok := settings.Get("Interval")
+// getConfig returns the configuration loaded last.
+func getConfig() *config.Config {
+	cfg, ok := currentConfig.Load().(*config.Config)
 	if !ok {
-		interval = 30
-	} else {
-		interval, _ = strconv.Atoi(intervalStr)
+		return loadConfig()
 	}
-	return interval
+	return cfg
 }
 
-func getSuccessiveSuccessful() bool {
-	settings := walk.App().Settings()
-	ssBuildsStr, ok := settings.Get("Successive_successful")
-	var ssBuilds bool
-	if !ok {
-		ssBuilds = false
-	} else {
-		ssBuilds, _ = strconv.ParseBool(ssBuildsStr)
+func loadJobs() []*job {
+	cfg := getConfig()
+	ownItems := make([]*job, len(cfg.Jobs))
+	for i, item := range cfg.Jobs {
+		ownItems[i] = &job{
+			Name:    item.Name,
+			Jenkins: item.Instance,
+		}
 	}
-	return ssBuilds
+	return substractAndFilterArray(ownItems, []*job{}, "")
 }
 
-func getJobsURLs() []string {
-	settings := walk.App().Settings()
-
-	jenkinsURL, ok := settings.Get("CC_URL")
-	if ok {
-		settings.Remove("CC_URL")
-		settings.Put("URL_0", jenkinsURL)
-		settings.Save()
-		return []string{jenkinsURL}
-	}
-
-	var jenkinsURLs []string
-	ok = true
-	for i := 0; ok; i++ {
-		jenkinsURL, ok = settings.Get("URL_" + strconv.Itoa(i))
-		if ok {
-			jenkinsURLs = append(jenkinsURLs, jenkinsURL)
+func saveJobs(ownItems []*job) {
+	cfg := getConfig()
+	watchedJobs := make([]config.Job, len(ownItems))
+	for i, item := range ownItems {
+		watchedJobs[i] = config.Job{
+			Name:     item.Name,
+			Instance: item.Jenkins,
+			Groups:   jobTags(cfg, item),
 		}
 	}
-	if len(jenkinsURLs) > 0 {
-		return jenkinsURLs
+	if err := config.PutJobs(walk.App().Settings(), watchedJobs); err != nil {
+		log.Println("saveJobs:", err)
 	}
-
-	return []string{"http://hudson.pdv.lan/", "http://hudson.pdv.lan:8090/view/All%20Flat"}
+	trackSubscription(watchedJobs)
 }
 
 func contains(haystack []string, needle string) bool {
@@ -0,0 +1,129 @@
+// Package smtpmail composes the notification mails and sends them by SMTP, optionally with
+// STARTTLS and PLAIN authentication.
+package smtpmail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server is an SMTP server mails are sent with.
+type Server struct {
+	Host     string
+	Port     int
+	StartTLS bool
+	// Username and Password authenticate with PLAIN if Username is set.
+	Username string
+	Password string
+	// TLS configures STARTTLS. Nil verifies the certificate of Host with the system roots.
+	TLS *tls.Config
+}
+
+// Send delivers msg from one sender to one recipient.
+func (s Server) Send(from, to string, msg []byte) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if s.StartTLS {
+		tlsConfig := s.TLS
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: s.Host}
+		}
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	if err = c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Entry is one event listed in a mail.
+type Entry struct {
+	Time    time.Time
+	Status  string
+	Message string
+	URL     string
+}
+
+var mailHTML = template.Must(template.New("mail").Parse(`<html><body>
+<table cellpadding="4">
+{{range .}}<tr>
+<td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+<td><b>{{.Status}}</b></td>
+<td><a href="{{.URL}}">{{.Message}}</a></td>
+</tr>
+{{end}}</table>
+</body></html>
+`))
+
+// Compose builds a multipart/alternative message with a plain text and a HTML part listing
+// the entries. name is shown as the sender.
+func Compose(name, from, to, subject string, entries []Entry, now time.Time) ([]byte, error) {
+	var text strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&text, "%s  %-8s %s\r\n    %s\r\n",
+			e.Time.Format("2006-01-02 15:04:05"), e.Status, e.Message, e.URL)
+	}
+	var html bytes.Buffer
+	err := mailHTML.Execute(&html, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var b [12]byte
+	rand.Read(b[:])
+	boundary := hex.EncodeToString(b[:])
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s <%s>\r\n", mime.QEncoding.Encode("utf-8", name), from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", boundary)
+	msg.WriteString(text.String())
+	fmt.Fprintf(&msg, "\r\n--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n", boundary)
+	msg.Write(bytes.ReplaceAll(html.Bytes(), []byte("\n"), []byte("\r\n")))
+	fmt.Fprintf(&msg, "\r\n--%s--\r\n", boundary)
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,342 @@
+package smtpmail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// received is a mail accepted by the stand-in.
+type received struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	user string
+}
+
+// standIn is a local SMTP server accepting mails with optional STARTTLS and PLAIN
+// authentication.
+type standIn struct {
+	listener net.Listener
+	// tls offers STARTTLS if set.
+	tls *tls.Config
+	// user and password are required if user is set.
+	user     string
+	password string
+
+	mutex sync.Mutex
+	mails []received
+}
+
+func startStandIn(t *testing.T, tlsConfig *tls.Config, user, password string) *standIn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &standIn{listener: listener, tls: tlsConfig, user: user, password: password}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *standIn) server() Server {
+	return Server{Host: "127.0.0.1", Port: s.listener.Addr().(*net.TCPAddr).Port}
+}
+
+func (s *standIn) received() []received {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]received(nil), s.mails...)
+}
+
+func (s *standIn) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	tp := textproto.NewConn(conn)
+	var mail received
+	authenticated := s.user == ""
+	tp.PrintfLine("220 stand-in ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := strings.TrimSpace(line[len(verb):])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-stand-in")
+			if s.tls != nil && !mail.tls {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			if s.user != "" {
+				tp.PrintfLine("250-AUTH PLAIN")
+			}
+			tp.PrintfLine("250 8BITMIME")
+		case "STARTTLS":
+			if s.tls == nil {
+				tp.PrintfLine("502 not supported")
+				continue
+			}
+			tp.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			mail.tls = true
+		case "AUTH":
+			fields := strings.Fields(arg)
+			if len(fields) != 2 || fields[0] != "PLAIN" {
+				tp.PrintfLine("504 only PLAIN")
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			parts := strings.Split(string(decoded), "\x00")
+			if err != nil || len(parts) != 3 || parts[1] != s.user || parts[2] != s.password {
+				tp.PrintfLine("535 authentication failed")
+				continue
+			}
+			authenticated = true
+			mail.user = parts[1]
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			if !authenticated {
+				tp.PrintfLine("530 authentication required")
+				continue
+			}
+			mail.from = address(arg)
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, address(arg))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = string(data)
+			s.mutex.Lock()
+			s.mails = append(s.mails, mail)
+			s.mutex.Unlock()
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+// address returns the address in angle brackets of a MAIL or RCPT command.
+func address(arg string) string {
+	start, end := strings.Index(arg, "<"), strings.Index(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
+
+// selfSigned returns the server and client TLS configurations of a certificate for
+// 127.0.0.1.
+func selfSigned(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stand-in"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+	return server, client
+}
+
+func TestSend(t *testing.T) {
+	standIn := startStandIn(t, nil, "", "")
+	err := standIn.server().Send("jenkins@example.com", "dev@example.com", []byte("Subject: hi\r\n\r\nbody\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mails := standIn.received()
+	if len(mails) != 1 {
+		t.Fatalf("received %d mails, want 1", len(mails))
+	}
+	m := mails[0]
+	if m.from != "jenkins@example.com" || len(m.to) != 1 || m.to[0] != "dev@example.com" {
+		t.Errorf("envelope %s -> %v", m.from, m.to)
+	}
+	if m.data != "Subject: hi\n\nbody\n" {
+		t.Errorf("data = %q", m.data)
+	}
+	if m.tls || m.user != "" {
+		t.Errorf("tls %v, user %q, want neither", m.tls, m.user)
+	}
+}
+
+func TestSendStartTLSWithAuth(t *testing.T) {
+	serverTLS, clientTLS := selfSigned(t)
+	standIn := startStandIn(t, serverTLS, "jc", "hunter2")
+	server := standIn.server()
+	server.StartTLS = true
+	server.TLS = clientTLS
+	server.Username = "jc"
+	server.Password = "hunter2"
+	if err := server.Send("jenkins@example.com", "dev@example.com", []byte("body\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	mails := standIn.received()
+	if len(mails) != 1 || !mails[0].tls || mails[0].user != "jc" {
+		t.Errorf("received %+v, want one mail over TLS from jc", mails)
+	}
+}
+
+func TestSendFailures(t *testing.T) {
+	serverTLS, clientTLS := selfSigned(t)
+	standIn := startStandIn(t, serverTLS, "jc", "hunter2")
+	tests := []struct {
+		name  string
+		setup func(s *Server)
+		want  string
+	}{
+		{"wrong password", func(s *Server) {
+			s.StartTLS, s.TLS, s.Username, s.Password = true, clientTLS, "jc", "wrong"
+		}, "535"},
+		{"no authentication", func(s *Server) {
+			s.StartTLS, s.TLS = true, clientTLS
+		}, "530"},
+		{"untrusted certificate", func(s *Server) {
+			s.StartTLS, s.Username, s.Password = true, "jc", "hunter2"
+		}, "certificate"},
+	}
+	for _, test := range tests {
+		server := standIn.server()
+		test.setup(&server)
+		err := server.Send("jenkins@example.com", "dev@example.com", []byte("body\r\n"))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: Send = %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+	if mails := standIn.received(); len(mails) != 0 {
+		t.Errorf("received %d mails, want none", len(mails))
+	}
+	closed := standIn.server()
+	standIn.listener.Close()
+	if err := closed.Send("jenkins@example.com", "dev@example.com", []byte("body\r\n")); err == nil {
+		t.Error("Send succeeded without server")
+	}
+}
+
+func TestCompose(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Time: now.Add(-time.Minute), Status: "FAILURE", Message: "product <build> failed", URL: "http://jenkins-a/job/product/"},
+		{Time: now, Status: "SUCCESS", Message: "tools is back to normal", URL: "http://jenkins-a/job/tools/"},
+	}
+	data, err := Compose("JenkinsCheck", "jenkins@example.com", "dev@example.com", "2 jobs änderten sich", entries, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "2 jobs änderten sich" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Name != "JenkinsCheck" || from[0].Address != "jenkins@example.com" {
+		t.Errorf("From = %v, %v", from, err)
+	}
+	if date, err := msg.Header.Date(); err != nil || !date.Equal(now) {
+		t.Errorf("Date = %v, %v", date, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %s, %v", mediaType, err)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	want := []struct {
+		contentType string
+		contains    []string
+	}{
+		{"text/plain; charset=utf-8", []string{
+			"2020-06-15 11:59:00  FAILURE  product <build> failed\r\n    http://jenkins-a/job/product/",
+			"2020-06-15 12:00:00  SUCCESS  tools is back to normal",
+		}},
+		{"text/html; charset=utf-8", []string{
+			`<a href="http://jenkins-a/job/product/">product &lt;build&gt; failed</a>`,
+			"<td><b>SUCCESS</b></td>",
+		}},
+	}
+	for _, w := range want {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := part.Header.Get("Content-Type"); got != w.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, w.contentType)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range w.contains {
+			if !strings.Contains(string(body), s) {
+				t.Errorf("%s part does not contain %q:\n%s", w.contentType, s, body)
+			}
+		}
+	}
+	if _, err := parts.NextPart(); err == nil {
+		t.Error("more than two parts")
+	}
+}
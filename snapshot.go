@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotFileName is the file next to the log the last known state of the jobs is kept in.
+const snapshotFileName = "snapshot.json"
+
+// snapshotBuild has the fields of build without the conversion from the Jenkins API.
+type snapshotBuild struct {
+	Building    bool
+	Label       int
+	Result      string
+	Timestamp   time.Time
+	Claimable   bool
+	ClaimedBy   string
+	ClaimReason string
+	Culprits    string
+	FailCount   int
+	SkipCount   int
+	TotalCount  int
+}
+
+// snapshotJob is the state of a job at the time it was polled.
+type snapshotJob struct {
+	Name               string
+	Instance           string
+	URL                string
+	InQueue            bool
+	LastBuild          snapshotBuild
+	LastCompletedBuild snapshotBuild
+	Updated            time.Time
+}
+
+// writeSnapshot replaces the snapshot at path with the state of the jobs that was polled at
+// least once.
+func writeSnapshot(path string, jobs []*job) error {
+	entries := make([]snapshotJob, 0, len(jobs))
+	for _, j := range jobs {
+		updated := j.updated()
+		if updated.IsZero() {
+			continue
+		}
+		entries = append(entries, snapshotJob{
+			Name:               j.Name,
+			Instance:           j.Jenkins,
+			URL:                j.URL,
+			InQueue:            j.InQueue,
+			LastBuild:          snapshotBuild(j.LastBuild),
+			LastCompletedBuild: snapshotBuild(j.LastCompletedBuild),
+			Updated:            updated,
+		})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreSnapshot sets the jobs to their state in the snapshot at path, marked as stale until
+// they are polled again.
+func restoreSnapshot(path string, jobs []*job) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Println("snapshot:", err)
+		return
+	}
+	var entries []snapshotJob
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Println("snapshot:", err)
+		return
+	}
+	for _, j := range jobs {
+		for _, entry := range entries {
+			if entry.Name == j.Name && entry.Instance == j.Jenkins {
+				j.URL = entry.URL
+				j.InQueue = entry.InQueue
+				j.LastBuild = build(entry.LastBuild)
+				j.LastCompletedBuild = build(entry.LastCompletedBuild)
+				j.polled = entry.Updated
+				j.Stale = entry.Updated
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"JenkinsCheck/config"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+// subscribe re-reads the subscribed watch list at its interval until stop is closed.
+// refresh triggers an immediate read.
+func (mw *jenkinsMainWindow) subscribe(refresh <-chan struct{}, stop <-chan struct{}) {
+	defer handlePanic()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-refresh:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-stop:
+			return
+		}
+		subscription := getConfig().Subscription
+		if subscription.Source != "" {
+			if err := mw.updateSubscription(subscription.Source); err != nil {
+				log.Println("subscription:", err)
+			}
+		}
+		timer.Reset(time.Duration(subscription.Interval) * time.Minute)
+	}
+}
+
+// updateSubscription reads the watch list from source and merges it into the settings on
+// the UI thread.
+func (mw *jenkinsMainWindow) updateSubscription(source string) error {
+	list, err := config.ReadWatchList(source, http.DefaultClient)
+	if err != nil {
+		return err
+	}
+	mw.Synchronize(func() {
+		defer handlePanic()
+		if err := mw.applySubscription(source, list); err != nil {
+			log.Println("subscription:", err)
+		}
+	})
+	return nil
+}
+
+// applySubscription merges the watch list into the settings if it changed.
+func (mw *jenkinsMainWindow) applySubscription(source string, list *config.WatchList) error {
+	settings := walk.App().Settings()
+	state, err := config.LoadSubscriptionState(settings)
+	if err != nil {
+		log.Println("subscription:", err)
+	}
+	if state.List != nil && reflect.DeepEqual(state.List, list) {
+		return nil
+	}
+	merged := state.Apply(getConfig().WatchList(), list)
+	if err := config.PutWatchList(settings, merged); err != nil {
+		return err
+	}
+	if err := state.Put(settings); err != nil {
+		return err
+	}
+	if err := settings.Save(); err != nil {
+		return err
+	}
+	log.Println("subscription: applied watch list from", source)
+	mw.reInit()
+	return nil
+}
+
+// trackSubscription records the monitored jobs as personal changes to the subscribed ones.
+func trackSubscription(jobs []config.Job) {
+	if getConfig().Subscription.Source == "" {
+		return
+	}
+	settings := walk.App().Settings()
+	state, err := config.LoadSubscriptionState(settings)
+	if err != nil {
+		log.Println("subscription:", err)
+	}
+	state.Track(jobs)
+	if err := state.Put(settings); err != nil {
+		log.Println("subscription:", err)
+	}
+}
+
+// importWatchList adds the jobs, instances and groups of a watch list file to the settings.
+func (mw *jenkinsMainWindow) importWatchList() {
+	defer handlePanic()
+	fileDlg := &walk.FileDialog{
+		Title:  "Import watch list",
+		Filter: "Watch lists (*.json)|*.json",
+	}
+	ok, err := fileDlg.ShowOpen(mw)
+	if err != nil {
+		log.Println(err)
+	}
+	if !ok {
+		return
+	}
+	list, err := config.ReadWatchList(fileDlg.FilePath, http.DefaultClient)
+	if err != nil {
+		walk.MsgBox(mw, "Import watch list", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	current := getConfig().WatchList()
+	current.Merge(list)
+	settings := walk.App().Settings()
+	if err := config.PutWatchList(settings, current); err != nil {
+		walk.MsgBox(mw, "Import watch list", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	trackSubscription(current.Jobs)
+	if err := settings.Save(); err != nil {
+		log.Println(err)
+	}
+	mw.reInit()
+}
+
+// exportWatchList writes the monitored jobs with their instances and groups to a file.
+func (mw *jenkinsMainWindow) exportWatchList() {
+	defer handlePanic()
+	fileDlg := &walk.FileDialog{
+		Title:    "Export watch list",
+		Filter:   "Watch lists (*.json)|*.json",
+		FilePath: "watchlist.json",
+	}
+	ok, err := fileDlg.ShowSave(mw)
+	if err != nil {
+		log.Println(err)
+	}
+	if !ok {
+		return
+	}
+	if err := getConfig().WatchList().WriteFile(fileDlg.FilePath); err != nil {
+		walk.MsgBox(mw, "Export watch list", err.Error(), walk.MsgBoxIconError)
+	}
+}
+
+// openSubscriptionDialog edits the subscribed watch list. An empty source unsubscribes and
+// keeps the current jobs.
+func (mw *jenkinsMainWindow) openSubscriptionDialog() {
+	defer handlePanic()
+	subscription := getConfig().Subscription
+
+	var dlg *walk.Dialog
+	var sourceBox *walk.LineEdit
+	var intervalBox *walk.NumberEdit
+	var okPB, cancelPB *walk.PushButton
+	err := Dialog{
+		AssignTo:      &dlg,
+		Title:         "Subscribe to watch list",
+		Icon:          mw.Icon(),
+		DefaultButton: &okPB,
+		CancelButton:  &cancelPB,
+		MinSize:       Size{Width: 500},
+		Layout:        VBox{},
+		Children: []Widget{
+			Composite{
+				Layout: Grid{Columns: 3},
+				Children: []Widget{
+					Label{Text: "File or URL (leave empty to unsubscribe):"},
+					LineEdit{
+						AssignTo: &sourceBox,
+						Text:     subscription.Source,
+					},
+					PushButton{
+						Text: "Browse",
+						OnClicked: func() {
+							fileDlg := &walk.FileDialog{Filter: "Watch lists (*.json)|*.json"}
+							ok, err := fileDlg.ShowOpen(dlg)
+							if err != nil {
+								log.Println(err)
+							}
+							if ok {
+								sourceBox.SetText(fileDlg.FilePath)
+							}
+						},
+					},
+					Label{Text: "Interval (in minutes):"},
+					NumberEdit{
+						AssignTo: &intervalBox,
+						Value:    float64(subscription.Interval),
+						MinValue: 1,
+						MaxValue: 7 * 24 * 60,
+					},
+					HSpacer{},
+				},
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &okPB,
+						Text:     "Ok",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdOK)
+						},
+					},
+					PushButton{
+						AssignTo: &cancelPB,
+						Text:     "Cancel",
+						OnClicked: func() {
+							dlg.Close(walk.DlgCmdCancel)
+						},
+					},
+				},
+			},
+		},
+	}.Create(mw)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if dlg.Run() != walk.DlgCmdOK {
+		return
+	}
+
+	settings := walk.App().Settings()
+	source := sourceBox.Text()
+	if source == "" {
+		settings.Remove(config.KeySubscription)
+		settings.Remove(config.KeySubscriptionState)
+	} else {
+		if source != subscription.Source {
+			settings.Remove(config.KeySubscriptionState)
+		}
+		subscriptionJSON, _ := json.Marshal(config.Subscription{
+			Source:   source,
+			Interval: int(intervalBox.Value()),
+		})
+		settings.Put(config.KeySubscription, string(subscriptionJSON))
+	}
+	if err := settings.Save(); err != nil {
+		log.Println(err)
+	}
+	loadConfig()
+	if source == "" {
+		log.Println("subscription: unsubscribed")
+	}
+	select {
+	case mw.refreshSubscription <- struct{}{}:
+	default:
+	}
+}
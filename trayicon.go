@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/lxn/walk"
+)
+
+var levelColors = map[string]color.RGBA{
+	"success":  {R: 60, G: 180, B: 60, A: 255},
+	"unstable": {R: 230, G: 200, B: 0, A: 255},
+	"failure":  {R: 210, G: 30, B: 30, A: 255},
+	"unknown":  {R: 150, G: 150, B: 150, A: 255},
+}
+
+// maxToolTip is the length of the notify icon's tool tip buffer without the terminating zero.
+const maxToolTip = 127
+
+// trayIcon shows the aggregate state of all monitored jobs in the notify icon.
+type trayIcon struct {
+	ni    *walk.NotifyIcon
+	form  walk.Form
+	icons map[string]*walk.Icon
+}
+
+func newTrayIcon(ni *walk.NotifyIcon, form walk.Form, fallback *walk.Icon) *trayIcon {
+	t := &trayIcon{
+		ni:    ni,
+		form:  form,
+		icons: make(map[string]*walk.Icon),
+	}
+	for level := range levelColors {
+		for _, building := range []bool{false, true} {
+			icon, err := walk.NewIconFromImage(drawStatusImage(levelColors[level], building))
+			if err != nil {
+				log.Println("tray icon:", err)
+				icon = fallback
+			}
+			t.icons[fmt.Sprint(level, building)] = icon
+		}
+	}
+	return t
+}
+
+func (t *trayIcon) JobsChanged(changed []*job, state aggregateState) {
+	icon := t.icons[fmt.Sprint(state.level(), state.Building > 0)]
+	toolTip := trayToolTip(state)
+	t.form.Synchronize(func() {
+		defer handlePanic()
+		if err := t.ni.SetIcon(icon); err != nil {
+			log.Println("tray icon:", err)
+		}
+		if err := t.ni.SetToolTip(toolTip); err != nil {
+			log.Println("tray icon:", err)
+		}
+	})
+}
+
+// trayToolTip lists the counts followed by the failing jobs, cut to fit the tool tip.
+func trayToolTip(state aggregateState) string {
+	var counts []string
+	if len(state.Failing) > 0 {
+		counts = append(counts, fmt.Sprintf("%d failing", len(state.Failing)))
+	}
+	if len(state.Unstable) > 0 {
+		counts = append(counts, fmt.Sprintf("%d unstable", len(state.Unstable)))
+	}
+	if state.Building > 0 {
+		counts = append(counts, fmt.Sprintf("%d building", state.Building))
+	}
+	if len(state.Unreachable) > 0 {
+		counts = append(counts, fmt.Sprintf("%d unreachable", len(state.Unreachable)))
+	}
+	if len(counts) == 0 {
+		counts = append(counts, fmt.Sprintf("%d jobs ok", state.Total))
+	}
+	lines := append([]string{walk.App().ProductName() + ": " + strings.Join(counts, ", ")}, state.Failing...)
+	toolTip := strings.Join(lines, "\n")
+	if len(utf16.Encode([]rune(toolTip))) <= maxToolTip {
+		return toolTip
+	}
+	runes := []rune(toolTip)
+	for len(utf16.Encode(runes)) > maxToolTip-1 {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// drawStatusImage draws a filled circle in the given color. Building adds a small blue dot
+// in the lower right corner.
+func drawStatusImage(fill color.RGBA, building bool) image.Image {
+	const size = 32
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	outline := color.RGBA{R: fill.R / 2, G: fill.G / 2, B: fill.B / 2, A: 255}
+	drawCircle(img, 15.5, 15.5, 14, outline)
+	drawCircle(img, 15.5, 15.5, 12.5, fill)
+	if building {
+		drawCircle(img, 24.5, 24.5, 7, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		drawCircle(img, 24.5, 24.5, 5.5, color.RGBA{R: 30, G: 90, B: 220, A: 255})
+	}
+	return img
+}
+
+func drawCircle(img *image.RGBA, cx, cy, r float64, c color.RGBA) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dx, dy := float64(x)-cx, float64(y)-cy
+			if dx*dx+dy*dy <= r*r {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
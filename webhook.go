@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type webhookPayload struct {
+	Message  string      `json:"message"`
+	Severity string      `json:"severity"`
+	Events   []eventData `json:"events"`
+}
+
+// webhookNotifier posts events as JSON to an URL.
+type webhookNotifier struct {
+	url   string
+	queue chan queuedEvent
+	done  chan struct{}
+	guard queueGuard
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	n := &webhookNotifier{
+		url:   url,
+		queue: make(chan queuedEvent, 100),
+		done:  make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+func (n *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *webhookNotifier) Notify(ev *jobEvent, done func(err error)) {
+	err := n.guard.enqueue(func() bool {
+		select {
+		case n.queue <- queuedEvent{ev: ev, done: done}:
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil {
+		done(err)
+	}
+}
+
+func (n *webhookNotifier) run() {
+	defer handlePanic()
+	for {
+		select {
+		case queued := <-n.queue:
+			if err := n.post(queued.ev); err != nil {
+				queued.done(fmt.Errorf("%s: %v", n.url, err))
+			} else {
+				queued.done(nil)
+			}
+		case <-n.done:
+			drain(n.queue)
+			return
+		}
+	}
+}
+
+func (n *webhookNotifier) Close() {
+	n.guard.close(func() {
+		close(n.done)
+	})
+}
+
+func (n *webhookNotifier) post(ev *jobEvent) error {
+	payload := webhookPayload{
+		Message:  ev.Message,
+		Severity: ev.Severity.String(),
+	}
+	for _, member := range ev.members() {
+		payload.Events = append(payload.Events, member.data())
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("response was not OK: %d", resp.StatusCode)
+	}
+	return nil
+}